@@ -0,0 +1,30 @@
+package client
+
+import (
+	"github.com/emersion/go-imap"
+)
+
+// addressesToList converte gli indirizzi dell'envelope in oggetti { name, address, mailbox, host }
+// name è il nome visualizzato già decodificato (RFC 2047), stringa vuota se manca
+// Gli indirizzi senza dominio (indirizzi locali o l'inizio di un gruppo RFC 5322, es. "undisclosed-recipients:;")
+// restano nell'array con host vuoto e address uguale a mailbox, così il numero di elementi corrisponde all'header
+// La fine di un gruppo (mailbox e host vuoti) non è un indirizzo e viene saltata
+func addressesToList(addrs []*imap.Address) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(addrs))
+	for _, addr := range addrs {
+		if addr == nil || (addr.MailboxName == "" && addr.HostName == "") {
+			continue
+		}
+		address := addr.MailboxName
+		if addr.HostName != "" {
+			address = addr.Address()
+		}
+		result = append(result, map[string]interface{}{
+			"name":    addr.PersonalName,
+			"address": address,
+			"mailbox": addr.MailboxName,
+			"host":    addr.HostName,
+		})
+	}
+	return result
+}
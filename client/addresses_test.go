@@ -0,0 +1,75 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestAddressesToList(t *testing.T) {
+	tests := []struct {
+		name  string
+		addrs []*imap.Address
+		want  []map[string]interface{}
+	}{
+		{
+			name:  "no addresses",
+			addrs: nil,
+			want:  []map[string]interface{}{},
+		},
+		{
+			name: "display name and plain address",
+			addrs: []*imap.Address{
+				{PersonalName: "Acme Billing", MailboxName: "billing", HostName: "acme.com"},
+				{MailboxName: "ops", HostName: "example.com"},
+			},
+			want: []map[string]interface{}{
+				{"name": "Acme Billing", "address": "billing@acme.com", "mailbox": "billing", "host": "acme.com"},
+				{"name": "", "address": "ops@example.com", "mailbox": "ops", "host": "example.com"},
+			},
+		},
+		{
+			name: "undisclosed recipients group",
+			addrs: []*imap.Address{
+				{MailboxName: "undisclosed-recipients"},
+				{},
+			},
+			want: []map[string]interface{}{
+				{"name": "", "address": "undisclosed-recipients", "mailbox": "undisclosed-recipients", "host": ""},
+			},
+		},
+		{
+			name: "group with members",
+			addrs: []*imap.Address{
+				{MailboxName: "team"},
+				{MailboxName: "anna", HostName: "example.com"},
+				{MailboxName: "luca", HostName: "example.com"},
+				{},
+			},
+			want: []map[string]interface{}{
+				{"name": "", "address": "team", "mailbox": "team", "host": ""},
+				{"name": "", "address": "anna@example.com", "mailbox": "anna", "host": "example.com"},
+				{"name": "", "address": "luca@example.com", "mailbox": "luca", "host": "example.com"},
+			},
+		},
+		{
+			name: "local address",
+			addrs: []*imap.Address{
+				{PersonalName: "Root", MailboxName: "root"},
+				nil,
+			},
+			want: []map[string]interface{}{
+				{"name": "Root", "address": "root", "mailbox": "root", "host": ""},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := addressesToList(tt.addrs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("addressesToList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/grafana/sobek"
+)
+
+// Append aggiunge un messaggio RFC 5322 (stringa o ArrayBuffer) alla mailbox indicata
+// appendOpts è facoltativo: { flags, internalDate }, dove internalDate è una Date o un timestamp in millisecondi
+// Se il server supporta UIDPLUS (RFC 4315) restituisce { uid, uidValidity } del nuovo messaggio, altrimenti entrambi null
+// (0 non va usato come UID: in un set IMAP indica il messaggio più recente)
+// Usage da JavaScript: const [result, err] = client.append("INBOX", raw, { flags: ["\\Seen"] })
+func (e *EmailClient) Append(mailbox string, rawMessage interface{}, appendOpts map[string]interface{}) (map[string]interface{}, error) {
+	e.lock()
+	defer e.unlock()
+
+	if e.client == nil {
+		return nil, notConnected()
+	}
+
+	if mailbox == "" {
+		return nil, errors.New("mailbox name must not be empty")
+	}
+
+	var data []byte
+	switch v := rawMessage.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	case sobek.ArrayBuffer:
+		data = v.Bytes()
+	default:
+		return nil, errors.New("message must be a string or an ArrayBuffer")
+	}
+
+	if len(data) == 0 {
+		return nil, errors.New("message must not be empty")
+	}
+
+	flags, err := optionStrings(appendOpts, "flags", "flags")
+	if err != nil {
+		return nil, err
+	}
+
+	date, err := parseDateOption(appendOpts["internalDate"], "internalDate")
+	if err != nil {
+		return nil, err
+	}
+
+	// client.Append non espone il response code: il comando viene eseguito direttamente per leggere APPENDUID
+	cmd := &commands.Append{
+		Mailbox: mailbox,
+		Flags:   flags,
+		Date:    date,
+		Message: bytes.NewBuffer(data),
+	}
+
+	var status *imap.StatusResp
+	err = e.track("append", mailbox, func() error {
+		var err error
+		status, err = e.execute(cmd, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{
+		"uid":         nil,
+		"uidValidity": nil,
+	}
+
+	// OK [APPENDUID <uidvalidity> <uid>]
+	if status.Code == "APPENDUID" && len(status.Arguments) >= 2 {
+		uidValidity, err := imap.ParseNumber(status.Arguments[0])
+		if err != nil {
+			return nil, newError(ErrCodeProtocol, "invalid APPENDUID response: %v", err)
+		}
+		uid, err := imap.ParseNumber(status.Arguments[1])
+		if err != nil {
+			return nil, newError(ErrCodeProtocol, "invalid APPENDUID response: %v", err)
+		}
+		result["uid"] = uid
+		result["uidValidity"] = uidValidity
+	}
+
+	return result, nil
+}
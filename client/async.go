@@ -0,0 +1,352 @@
+package client
+
+import (
+	"context"
+	"errors"
+
+	"github.com/grafana/sobek"
+	"go.k6.io/k6/js/promises"
+)
+
+// errContextDone indica che il contesto del VU è stato cancellato: k6 ha interrotto il VU (gracefulStop scaduto o test interrotto)
+// Una promise in sospeso impedisce all'iterazione di finire, quindi la fine normale di un'iterazione non lo provoca
+var errContextDone = errors.New("VU context done: the VU was interrupted")
+
+// async esegue fn in una goroutine e ne restituisce il risultato come promise
+// La promise viene risolta o rifiutata sempre sull'event loop del VU: promises.New registra un callback
+// con RegisterCallback e resolve/reject lo accodano, quindi possono essere chiamati da qualsiasi goroutine
+// fn non deve mai usare il runtime JavaScript e deve terminare appena ctx (il contesto del VU) viene cancellato,
+// altrimenti l'event loop resta in attesa del callback e l'iterazione non finisce
+func (e *EmailClient) async(fn func(ctx context.Context) (interface{}, error)) *sobek.Promise {
+	if e.Vu == nil {
+		panic("VU context not available. EmailClient must be created inside the default function, not in init context.")
+	}
+
+	promise, resolve, reject := promises.New(e.Vu)
+	ctx := e.Vu.Context()
+
+	go func() {
+		result, err := fn(ctx)
+		if err != nil {
+			reject(err)
+			return
+		}
+		resolve(result)
+	}()
+
+	return promise
+}
+
+// rejected restituisce una promise già rifiutata con err (ad esempio per opzioni non valide)
+func (e *EmailClient) rejected(err error) *sobek.Promise {
+	return e.async(func(context.Context) (interface{}, error) {
+		return nil, err
+	})
+}
+
+// asyncCall esegue in una goroutine un metodo sincrono del client e ne restituisce il risultato come promise,
+// rifiutata con l'errore del metodo
+// Gli argomenti vanno convertiti prima, sul thread del VU: fn non deve toccare oggetti JavaScript
+func (e *EmailClient) asyncCall(fn func() (interface{}, error)) *sobek.Promise {
+	return e.async(func(context.Context) (interface{}, error) {
+		return fn()
+	})
+}
+
+// I metodi seguenti sono le versioni asincrone dei metodi sincroni con lo stesso nome:
+// l'I/O di rete avviene fuori dal thread JavaScript, così websocket, timer e altre promise
+// dello stesso VU proseguono mentre il server IMAP risponde
+// Usage da JavaScript: const messages = await client.searchAsync({ subject: "Invoice" })
+
+// LoginAsync è la versione asincrona di Login
+// auth.refreshToken è una funzione JavaScript e non può essere chiamata dalla goroutine:
+// il token iniziale viene chiesto subito e, se il server lo rifiuta, il rinnovo avviene sull'event loop
+// senza tenere la connessione, poi l'autenticazione viene ripetuta in una nuova goroutine
+func (e *EmailClient) LoginAsync() *sobek.Promise {
+	// Mechanism e RefreshToken non cambiano dopo il costruttore, il token sì (vedi authToken)
+	refresh := e.Options.Auth.RefreshToken
+	if refresh == nil {
+		return e.async(func(context.Context) (interface{}, error) {
+			return nil, e.login(nil)
+		})
+	}
+
+	mechanism := e.Options.Auth.Mechanism
+	isOAuth := mechanism == AuthXOAuth2 || mechanism == AuthOAuthBearer
+	if isOAuth && e.authToken() == "" {
+		token, err := refresh()
+		if err != nil {
+			return e.rejected(err)
+		}
+		e.setAuthToken(token)
+	}
+
+	promise, resolve, reject := promises.New(e.Vu)
+	callback := e.Vu.RegisterCallback()
+
+	settle := func(err error) {
+		if err != nil {
+			reject(err)
+			return
+		}
+		resolve(nil)
+	}
+
+	go func() {
+		err := e.login(nil)
+		if !errors.Is(err, errTokenRejected) {
+			// Il callback registrato va sempre chiamato, altrimenti l'event loop resta in attesa
+			callback(func() error { return nil })
+			settle(err)
+			return
+		}
+
+		callback(func() error {
+			token, err := refresh()
+			if err != nil {
+				reject(err)
+				return nil
+			}
+			// Conserva il nuovo token per i login successivi
+			e.setAuthToken(token)
+
+			go func() {
+				settle(e.reauthenticate())
+			}()
+			return nil
+		})
+	}()
+
+	return promise
+}
+
+// authToken legge il token OAuth con la connessione acquisita: authenticate lo aggiorna
+// anche dalle goroutine di LoginAsync
+func (e *EmailClient) authToken() string {
+	e.lock()
+	defer e.unlock()
+	return e.Options.Auth.Token
+}
+
+// setAuthToken aggiorna il token OAuth con la connessione acquisita (vedi authToken)
+func (e *EmailClient) setAuthToken(token string) {
+	e.lock()
+	defer e.unlock()
+	e.Options.Auth.Token = token
+}
+
+// reauthenticate ripete l'autenticazione sulla connessione già aperta, dopo il rinnovo del token
+func (e *EmailClient) reauthenticate() error {
+	e.lock()
+	defer e.unlock()
+
+	if e.client == nil {
+		return notConnected()
+	}
+	return e.track("login", "", func() error {
+		return e.authenticate(nil)
+	})
+}
+
+// LogoutAsync è la versione asincrona di Logout
+func (e *EmailClient) LogoutAsync() *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		e.Logout()
+		return nil, nil
+	})
+}
+
+// ReadAsync è la versione asincrona di Read: la promise è rifiutata anche se nessun messaggio corrisponde
+func (e *EmailClient) ReadAsync(criteriaObj map[string]interface{}, readOpts map[string]interface{}) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return e.Read(criteriaObj, readOpts)
+	})
+}
+
+// SearchAsync è la versione asincrona di Search
+func (e *EmailClient) SearchAsync(criteriaObj map[string]interface{}, searchOpts map[string]interface{}) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return e.Search(criteriaObj, searchOpts)
+	})
+}
+
+// ListAsync è la versione asincrona di List
+func (e *EmailClient) ListAsync(criteriaObj map[string]interface{}, searchOpts map[string]interface{}) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return e.List(criteriaObj, searchOpts)
+	})
+}
+
+// FetchByUidAsync è la versione asincrona di FetchByUid
+func (e *EmailClient) FetchByUidAsync(uid int64, fetchOpts map[string]interface{}) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return e.FetchByUid(uid, fetchOpts)
+	})
+}
+
+// AppendAsync è la versione asincrona di Append
+func (e *EmailClient) AppendAsync(mailbox string, rawMessage interface{}, appendOpts map[string]interface{}) *sobek.Promise {
+	// Il contenuto di un ArrayBuffer può cambiare mentre la goroutine lo invia: se ne fa una copia
+	if buf, ok := rawMessage.(sobek.ArrayBuffer); ok {
+		rawMessage = append([]byte(nil), buf.Bytes()...)
+	}
+	return e.asyncCall(func() (interface{}, error) {
+		return e.Append(mailbox, rawMessage, appendOpts)
+	})
+}
+
+// CopyAsync è la versione asincrona di Copy
+func (e *EmailClient) CopyAsync(uids interface{}, dest string, copyOpts map[string]interface{}) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return nil, e.Copy(uids, dest, copyOpts)
+	})
+}
+
+// MoveAsync è la versione asincrona di Move
+func (e *EmailClient) MoveAsync(uids interface{}, dest string, moveOpts map[string]interface{}) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return nil, e.Move(uids, dest, moveOpts)
+	})
+}
+
+// FetchAttachmentAsync è la versione asincrona di FetchAttachment
+// La parte viene scaricata nella goroutine, mentre l'ArrayBuffer va creato sull'event loop perché usa il runtime
+func (e *EmailClient) FetchAttachmentAsync(uid int64, section string, fetchOpts map[string]interface{}) *sobek.Promise {
+	if e.Vu == nil {
+		panic("VU context not available. EmailClient must be created inside the default function, not in init context.")
+	}
+
+	promise, resolve, reject := promises.New(e.Vu)
+	callback := e.Vu.RegisterCallback()
+
+	go func() {
+		data, err := e.fetchAttachment(uid, section, fetchOpts)
+		callback(func() error {
+			if err != nil {
+				reject(err)
+				return nil
+			}
+			resolve(e.Vu.Runtime().NewArrayBuffer(data))
+			return nil
+		})
+	}()
+
+	return promise
+}
+
+// ListMailboxesAsync è la versione asincrona di ListMailboxes
+func (e *EmailClient) ListMailboxesAsync(pattern string) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return e.ListMailboxes(pattern)
+	})
+}
+
+// CreateMailboxAsync è la versione asincrona di CreateMailbox
+func (e *EmailClient) CreateMailboxAsync(name string) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return nil, e.CreateMailbox(name)
+	})
+}
+
+// DeleteMailboxAsync è la versione asincrona di DeleteMailbox
+func (e *EmailClient) DeleteMailboxAsync(name string) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return nil, e.DeleteMailbox(name)
+	})
+}
+
+// RenameMailboxAsync è la versione asincrona di RenameMailbox
+func (e *EmailClient) RenameMailboxAsync(existingName, newName string) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return nil, e.RenameMailbox(existingName, newName)
+	})
+}
+
+// SubscribeAsync è la versione asincrona di Subscribe
+func (e *EmailClient) SubscribeAsync(name string) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return nil, e.Subscribe(name)
+	})
+}
+
+// UnsubscribeAsync è la versione asincrona di Unsubscribe
+func (e *EmailClient) UnsubscribeAsync(name string) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return nil, e.Unsubscribe(name)
+	})
+}
+
+// StatusAsync è la versione asincrona di Status
+func (e *EmailClient) StatusAsync(name string, items []string) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return e.Status(name, items)
+	})
+}
+
+// MarkSeenAsync è la versione asincrona di MarkSeen
+func (e *EmailClient) MarkSeenAsync(uids interface{}, flagOpts map[string]interface{}) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return e.MarkSeen(uids, flagOpts)
+	})
+}
+
+// MarkUnseenAsync è la versione asincrona di MarkUnseen
+func (e *EmailClient) MarkUnseenAsync(uids interface{}, flagOpts map[string]interface{}) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return e.MarkUnseen(uids, flagOpts)
+	})
+}
+
+// FlagAsync è la versione asincrona di Flag
+func (e *EmailClient) FlagAsync(uids interface{}, flagOpts map[string]interface{}) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return e.Flag(uids, flagOpts)
+	})
+}
+
+// UnflagAsync è la versione asincrona di Unflag
+func (e *EmailClient) UnflagAsync(uids interface{}, flagOpts map[string]interface{}) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return e.Unflag(uids, flagOpts)
+	})
+}
+
+// AddKeywordsAsync è la versione asincrona di AddKeywords
+func (e *EmailClient) AddKeywordsAsync(uids interface{}, keywords interface{}, flagOpts map[string]interface{}) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return e.AddKeywords(uids, keywords, flagOpts)
+	})
+}
+
+// RemoveKeywordsAsync è la versione asincrona di RemoveKeywords
+func (e *EmailClient) RemoveKeywordsAsync(uids interface{}, keywords interface{}, flagOpts map[string]interface{}) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return e.RemoveKeywords(uids, keywords, flagOpts)
+	})
+}
+
+// ReplaceKeywordsAsync è la versione asincrona di ReplaceKeywords
+func (e *EmailClient) ReplaceKeywordsAsync(uids interface{}, keywords interface{}, flagOpts map[string]interface{}) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return e.ReplaceKeywords(uids, keywords, flagOpts)
+	})
+}
+
+// DeleteWhereAsync è la versione asincrona di DeleteWhere
+// I criteri sono controllati subito: criteri vuoti senza { all: true } rifiutano la promise senza toccare la mailbox
+func (e *EmailClient) DeleteWhereAsync(criteriaObj map[string]interface{}, deleteOpts map[string]interface{}) *sobek.Promise {
+	args, err := e.parseDeleteWhere(criteriaObj, deleteOpts)
+	if err != nil {
+		return e.rejected(err)
+	}
+	return e.async(func(context.Context) (interface{}, error) {
+		return e.deleteWhere(args)
+	})
+}
+
+// DeleteEmailsOlderThanAsync è la versione asincrona di DeleteEmailsOlderThan
+func (e *EmailClient) DeleteEmailsOlderThanAsync(beforeTimestampUnix int64, deleteOpts map[string]interface{}) *sobek.Promise {
+	return e.asyncCall(func() (interface{}, error) {
+		return e.DeleteEmailsOlderThan(beforeTimestampUnix, deleteOpts)
+	})
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/emersion/go-imap"
+)
+
+// sectionPattern valida i numeri di sezione IMAP delle parti (es. "2" o "1.3")
+var sectionPattern = regexp.MustCompile(`^[1-9][0-9]*(\.[1-9][0-9]*)*$`)
+
+// attachmentParts restituisce le parti foglia che non sono il corpo del messaggio:
+// allegati, immagini inline e messaggi inoltrati
+func attachmentParts(bs *imap.BodyStructure) []*mimePart {
+	text, html := textParts(bs)
+
+	var attachments []*mimePart
+	bs.Walk(func(path []int, part *imap.BodyStructure) bool {
+		if len(part.Parts) > 0 {
+			return true
+		}
+		section := sectionName(path)
+		if (text != nil && text.section == section) || (html != nil && html.section == section) {
+			return true
+		}
+		attachments = append(attachments, &mimePart{section: section, part: part})
+		return true
+	})
+	return attachments
+}
+
+// attachmentsToMap descrive gli allegati per JavaScript; il contenuto si scarica con FetchAttachment
+func attachmentsToMap(bs *imap.BodyStructure) []map[string]interface{} {
+	parts := attachmentParts(bs)
+	result := make([]map[string]interface{}, 0, len(parts))
+	for _, p := range parts {
+		result = append(result, partToMap(p.part, p.section))
+	}
+	return result
+}
+
+// findPart cerca la parte foglia con il numero di sezione indicato
+func findPart(bs *imap.BodyStructure, section string) *imap.BodyStructure {
+	var found *imap.BodyStructure
+	bs.Walk(func(path []int, part *imap.BodyStructure) bool {
+		if len(part.Parts) == 0 && sectionName(path) == section {
+			found = part
+		}
+		return found == nil
+	})
+	return found
+}
+
+// FetchAttachment scarica la parte section del messaggio con l'UID indicato e ne restituisce
+// il contenuto decodificato (base64 o quoted-printable) come ArrayBuffer
+// La parte viene recuperata solo ora con BODY.PEEK[section], senza scaricare il resto del messaggio
+// fetchOpts è facoltativo: { mailbox, uidValidity } come in FetchByUid
+// Usage da JavaScript: const [data, err] = client.fetchAttachment(message.uid, message.attachments[0].section)
+func (e *EmailClient) FetchAttachment(uid int64, section string, fetchOpts map[string]interface{}) (interface{}, error) {
+	data, err := e.fetchAttachment(uid, section, fetchOpts)
+	if err != nil {
+		return nil, err
+	}
+	return e.Vu.Runtime().NewArrayBuffer(data), nil
+}
+
+// fetchAttachment scarica e decodifica la parte indicata senza usare il runtime JavaScript
+func (e *EmailClient) fetchAttachment(uid int64, section string, fetchOpts map[string]interface{}) ([]byte, error) {
+	e.lock()
+	defer e.unlock()
+
+	if e.client == nil {
+		return nil, notConnected()
+	}
+
+	if uid <= 0 || uid > int64(^uint32(0)) {
+		return nil, fmt.Errorf("invalid UID %d", uid)
+	}
+
+	if !sectionPattern.MatchString(section) {
+		return nil, fmt.Errorf("invalid section %q", section)
+	}
+
+	if _, err := e.selectForUid(fetchOpts, true); err != nil {
+		return nil, err
+	}
+
+	uidSet := new(imap.SeqSet)
+	uidSet.AddNum(uint32(uid))
+
+	// BODYSTRUCTURE serve per conoscere il Content-Transfer-Encoding della parte
+	items := []imap.FetchItem{
+		imap.FetchUid,
+		imap.FetchBodyStructure,
+		imap.FetchItem("BODY.PEEK[" + section + "]"),
+	}
+
+	messages, err := e.fetch(uidSet, items)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(messages) == 0 {
+		return nil, newError(ErrCodeNotFound, "No message with UID %d", uid)
+	}
+	msg := messages[0]
+
+	if msg.BodyStructure == nil {
+		return nil, newError(ErrCodeProtocol, "No body structure for message with UID %d", uid)
+	}
+
+	part := findPart(msg.BodyStructure, section)
+	if part == nil {
+		return nil, newError(ErrCodeNotFound, "No part %s in message with UID %d", section, uid)
+	}
+
+	name, err := imap.ParseBodySectionName(imap.FetchItem("BODY[" + section + "]"))
+	if err != nil {
+		return nil, err
+	}
+
+	r := msg.GetBody(name)
+	if r == nil {
+		return nil, newError(ErrCodeProtocol, "Server did not return part %s of message with UID %d", section, uid)
+	}
+
+	// Gli allegati restano nel loro charset: si decodifica solo il Content-Transfer-Encoding
+	return decodePart(r, part.Encoding, "")
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestAttachmentParts(t *testing.T) {
+	tests := []struct {
+		name      string
+		structure *imap.BodyStructure
+		want      []string
+	}{
+		{
+			name:      "single text part",
+			structure: leaf("text", "plain"),
+			want:      nil,
+		},
+		{
+			name:      "single non-text part",
+			structure: leaf("application", "pdf"),
+			want:      []string{"1"},
+		},
+		{
+			name: "body and attachments",
+			structure: multipart("mixed",
+				multipart("alternative", leaf("text", "plain"), leaf("text", "html")),
+				attachment("application", "pdf", "invoice.pdf"),
+				attachment("text", "csv", "report.csv"),
+			),
+			want: []string{"2", "3"},
+		},
+		{
+			name: "inline image in related",
+			structure: multipart("related",
+				leaf("text", "html"),
+				leaf("image", "png"),
+			),
+			want: []string{"2"},
+		},
+		{
+			name: "text attachment before the body",
+			structure: multipart("mixed",
+				attachment("text", "plain", "notes.txt"),
+				leaf("text", "plain"),
+			),
+			want: []string{"1"},
+		},
+		{
+			name: "forwarded message",
+			structure: multipart("mixed",
+				leaf("text", "plain"),
+				&imap.BodyStructure{MIMEType: "message", MIMESubType: "rfc822", BodyStructure: leaf("text", "plain")},
+			),
+			want: []string{"2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, p := range attachmentParts(tt.structure) {
+				got = append(got, p.section)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("attachmentParts() sections = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindPart(t *testing.T) {
+	pdf := attachment("application", "pdf", "invoice.pdf")
+	html := leaf("text", "html")
+	structure := multipart("mixed", multipart("alternative", leaf("text", "plain"), html), pdf)
+
+	tests := []struct {
+		section string
+		want    *imap.BodyStructure
+	}{
+		{"1.2", html},
+		{"2", pdf},
+		{"1", nil}, // multipart, non una foglia
+		{"3", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.section, func(t *testing.T) {
+			if got := findPart(structure, tt.section); got != tt.want {
+				t.Errorf("findPart(%q) = %+v, want %+v", tt.section, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("single part", func(t *testing.T) {
+		single := leaf("application", "pdf")
+		if got := findPart(single, "1"); got != single {
+			t.Errorf("findPart(\"1\") = %+v, want the message itself", got)
+		}
+	})
+}
+
+func TestSectionPattern(t *testing.T) {
+	tests := []struct {
+		section string
+		want    bool
+	}{
+		{"1", true},
+		{"2.10.3", true},
+		{"0", false},
+		{"1.0", false},
+		{"1.", false},
+		{"HEADER", false},
+		{"1]BODY[2", false},
+	}
+
+	for _, tt := range tests {
+		if got := sectionPattern.MatchString(tt.section); got != tt.want {
+			t.Errorf("sectionPattern.MatchString(%q) = %v, want %v", tt.section, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-sasl"
+	"github.com/grafana/sobek"
+)
+
+// Meccanismi di autenticazione supportati dall'opzione auth.mechanism
+const (
+	AuthLogin       = "LOGIN" // comando IMAP LOGIN (default)
+	AuthPlain       = "PLAIN"
+	AuthXOAuth2     = "XOAUTH2"
+	AuthOAuthBearer = "OAUTHBEARER"
+	AuthCramMD5     = "CRAM-MD5"
+	AuthExternal    = "EXTERNAL"
+)
+
+// codeAuthenticationFailed è il response code (RFC 5530) restituito quando le credenziali non sono valide
+const codeAuthenticationFailed imap.StatusRespCode = "AUTHENTICATIONFAILED"
+
+// errTokenRejected indica che il server ha rifiutato il token OAuth e il rinnovo è lasciato al chiamante (vedi LoginAsync)
+var errTokenRejected = errors.New("OAuth token rejected")
+
+// AuthOptions descrive come autenticarsi sul server
+// Per XOAUTH2 e OAUTHBEARER Token è l'access token; RefreshToken, se presente,
+// viene chiamato per ottenerne uno nuovo quando il server risponde AUTHENTICATIONFAILED
+type AuthOptions struct {
+	Mechanism    string
+	Token        string
+	Identity     string
+	RefreshToken func() (string, error)
+}
+
+// parseAuthOptions converte l'oggetto JavaScript "auth" in AuthOptions
+func parseAuthOptions(obj map[string]interface{}) (AuthOptions, error) {
+	var opts AuthOptions
+	var err error
+
+	mechanism, err := optionString(obj, "mechanism", "auth.mechanism")
+	if err != nil {
+		return opts, err
+	}
+	opts.Mechanism = strings.ToUpper(mechanism)
+
+	switch opts.Mechanism {
+	case "":
+		opts.Mechanism = AuthLogin
+	case AuthLogin, AuthPlain, AuthXOAuth2, AuthOAuthBearer, AuthCramMD5, AuthExternal:
+	default:
+		return opts, fmt.Errorf("unsupported auth.mechanism %q", mechanism)
+	}
+
+	if opts.Token, err = optionString(obj, "token", "auth.token"); err != nil {
+		return opts, err
+	}
+	if opts.Identity, err = optionString(obj, "identity", "auth.identity"); err != nil {
+		return opts, err
+	}
+
+	if value, ok := obj["refreshToken"]; ok && value != nil {
+		fn, ok := value.(func(sobek.FunctionCall) sobek.Value)
+		if !ok {
+			return opts, fmt.Errorf("option auth.refreshToken must be a function")
+		}
+		opts.RefreshToken = func() (string, error) {
+			token, ok := fn(sobek.FunctionCall{This: sobek.Undefined()}).Export().(string)
+			if !ok || token == "" {
+				return "", errors.New("auth.refreshToken must return a non-empty string")
+			}
+			return token, nil
+		}
+	}
+
+	isOAuth := opts.Mechanism == AuthXOAuth2 || opts.Mechanism == AuthOAuthBearer
+	if isOAuth && opts.Token == "" && opts.RefreshToken == nil {
+		return opts, fmt.Errorf("auth.mechanism %s requires auth.token or auth.refreshToken", opts.Mechanism)
+	}
+
+	return opts, nil
+}
+
+// authenticate esegue l'autenticazione sul client già connesso
+// Con XOAUTH2/OAUTHBEARER, se il server risponde AUTHENTICATIONFAILED e c'è una
+// callback refresh, il token viene rinnovato e l'autenticazione ripetuta una volta
+// refresh è nil quando la callback non può essere chiamata da qui: in quel caso un token rifiutato
+// restituisce errTokenRejected se l'opzione auth.refreshToken è presente
+func (e *EmailClient) authenticate(refresh func() (string, error)) error {
+	auth := e.Options.Auth
+
+	if auth.Mechanism == "" || auth.Mechanism == AuthLogin {
+		disabled, err := e.client.Support("LOGINDISABLED")
+		if err != nil {
+			return err
+		}
+		if disabled {
+			return client.ErrLoginDisabled
+		}
+		if _, err := e.execute(&commands.Login{Username: e.Email, Password: e.Password}, nil); err != nil {
+			return err
+		}
+		return e.authenticated()
+	}
+
+	isOAuth := auth.Mechanism == AuthXOAuth2 || auth.Mechanism == AuthOAuthBearer
+
+	// Nessun token iniziale: chiedilo subito alla callback
+	if isOAuth && auth.Token == "" && refresh != nil {
+		token, err := refresh()
+		if err != nil {
+			return err
+		}
+		e.Options.Auth.Token = token
+	}
+
+	status, err := e.authenticateSASL()
+	if err != nil {
+		return err
+	}
+
+	if isOAuth && status.Code == codeAuthenticationFailed && refresh == nil && auth.RefreshToken != nil {
+		return fmt.Errorf("%w: %w", errTokenRejected, statusError(status))
+	}
+
+	if isOAuth && status.Code == codeAuthenticationFailed && refresh != nil {
+		token, err := refresh()
+		if err != nil {
+			return err
+		}
+		// Conserva il nuovo token per i login successivi
+		e.Options.Auth.Token = token
+
+		if status, err = e.authenticateSASL(); err != nil {
+			return err
+		}
+	}
+
+	if err := statusError(status); err != nil {
+		return err
+	}
+
+	return e.authenticated()
+}
+
+// authenticated porta il client nello stato autenticato dopo un LOGIN o AUTHENTICATE riuscito
+func (e *EmailClient) authenticated() error {
+	e.client.SetState(imap.AuthenticatedState, nil)
+
+	// Le capability cambiano dopo il login: rileggile (servono ad esempio per IDLE)
+	_, err := e.client.Capability()
+	return err
+}
+
+// authenticateSASL invia il comando AUTHENTICATE e restituisce la risposta del server
+// Replica client.Authenticate di go-imap per poter leggere il response code
+func (e *EmailClient) authenticateSASL() (*imap.StatusResp, error) {
+	mechanism, err := e.saslClient()
+	if err != nil {
+		return nil, err
+	}
+
+	name, ir, err := mechanism.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := e.client.SupportAuth(name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("server does not advertise AUTH=%s", name)
+	}
+
+	cmd := &commands.Authenticate{
+		Mechanism: name,
+	}
+
+	res := &responses.Authenticate{
+		Mechanism:       mechanism,
+		InitialResponse: ir,
+		RepliesCh:       make(chan []byte, 10),
+	}
+
+	irOk, err := e.client.Support("SASL-IR")
+	if err != nil {
+		return nil, err
+	}
+	if irOk {
+		cmd.InitialResponse = ir
+		res.InitialResponse = nil
+	}
+
+	return e.client.Execute(cmd, res)
+}
+
+// saslClient crea il client SASL per il meccanismo configurato
+func (e *EmailClient) saslClient() (sasl.Client, error) {
+	auth := e.Options.Auth
+
+	switch auth.Mechanism {
+	case AuthPlain:
+		return sasl.NewPlainClient(auth.Identity, e.Email, e.Password), nil
+	case AuthXOAuth2:
+		return &xoauth2Client{username: e.Email, token: auth.Token}, nil
+	case AuthOAuthBearer:
+		return sasl.NewOAuthBearerClient(&sasl.OAuthBearerOptions{
+			Username: e.Email,
+			Token:    auth.Token,
+			Host:     e.Url,
+			Port:     e.Port,
+		}), nil
+	case AuthCramMD5:
+		return &cramMD5Client{username: e.Email, secret: e.Password}, nil
+	case AuthExternal:
+		return sasl.NewExternalClient(auth.Identity), nil
+	}
+	return nil, fmt.Errorf("unsupported auth mechanism %q", auth.Mechanism)
+}
+
+// statusError converte una risposta NO/BAD in errore mantenendo il response code
+func statusError(status *imap.StatusResp) error {
+	if err := status.Err(); err != nil {
+		if status != nil {
+			// Conserva la risposta per ImapError (response code e testo del server)
+			return &responseError{status}
+		}
+		return err
+	}
+	return nil
+}
+
+// xoauth2Client implementa il meccanismo XOAUTH2 usato da Gmail e Microsoft 365
+type xoauth2Client struct {
+	username string
+	token    string
+}
+
+func (a *xoauth2Client) Start() (mech string, ir []byte, err error) {
+	ir = []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01")
+	return AuthXOAuth2, ir, nil
+}
+
+func (a *xoauth2Client) Next(challenge []byte) (response []byte, err error) {
+	// In caso di errore il server invia un JSON con i dettagli:
+	// si risponde vuoto per ricevere la risposta NO finale
+	return []byte{}, nil
+}
+
+// cramMD5Client implementa il meccanismo CRAM-MD5 (RFC 2195)
+type cramMD5Client struct {
+	username string
+	secret   string
+}
+
+func (a *cramMD5Client) Start() (mech string, ir []byte, err error) {
+	return AuthCramMD5, nil, nil
+}
+
+func (a *cramMD5Client) Next(challenge []byte) (response []byte, err error) {
+	mac := hmac.New(md5.New, []byte(a.secret))
+	mac.Write(challenge)
+	return []byte(a.username + " " + hex.EncodeToString(mac.Sum(nil))), nil
+}
@@ -0,0 +1,321 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+	"github.com/grafana/sobek"
+)
+
+// builderPart è una parte testuale di BuildMessage
+type builderPart struct {
+	contentType string
+	content     string
+}
+
+// builderAttachment è un allegato di BuildMessage
+type builderAttachment struct {
+	filename    string
+	contentType string
+	params      map[string]string
+	contentId   string
+	content     []byte
+}
+
+// BuildMessage compone un messaggio RFC 5322 a partire da un oggetto JavaScript
+// { from, to, cc, replyTo, subject, text, html, attachments, headers, date, messageId }
+// Gli indirizzi sono stringhe ("Acme Billing <billing@acme.com>", anche più indirizzi separati da virgola),
+// oggetti { name, address } o array di entrambi; gli allegati sono { filename, contentType, content, contentId }
+// con content stringa o ArrayBuffer
+// Gli header con caratteri non ASCII sono codificati RFC 2047, il testo in quoted-printable e gli allegati in base64
+func BuildMessage(spec map[string]interface{}) ([]byte, error) {
+	var h mail.Header
+
+	for _, field := range []struct{ key, header string }{
+		{"from", "From"},
+		{"to", "To"},
+		{"cc", "Cc"},
+		{"replyTo", "Reply-To"},
+	} {
+		addrs, err := parseAddresses(spec[field.key], field.key)
+		if err != nil {
+			return nil, err
+		}
+		h.SetAddressList(field.header, addrs)
+	}
+
+	subject, err := optionString(spec, "subject", "subject")
+	if err != nil {
+		return nil, err
+	}
+	if subject != "" {
+		h.SetSubject(subject)
+	}
+
+	date, err := parseDateOption(spec["date"], "date")
+	if err != nil {
+		return nil, err
+	}
+	if date.IsZero() {
+		date = time.Now()
+	}
+	h.SetDate(date)
+
+	messageId, err := optionString(spec, "messageId", "messageId")
+	if err != nil {
+		return nil, err
+	}
+	if messageId != "" {
+		h.SetMessageID(messageId)
+	} else if err := h.GenerateMessageID(); err != nil {
+		return nil, err
+	}
+
+	if err := addBuilderHeaders(&h, spec["headers"]); err != nil {
+		return nil, err
+	}
+
+	text, err := optionString(spec, "text", "text")
+	if err != nil {
+		return nil, err
+	}
+	html, err := optionString(spec, "html", "html")
+	if err != nil {
+		return nil, err
+	}
+
+	attachments, err := parseBuilderAttachments(spec["attachments"])
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	// Senza allegati il corpo è il messaggio stesso, altrimenti è la prima parte di un multipart/mixed
+	if len(attachments) == 0 {
+		if text != "" && html != "" {
+			w, err := mail.CreateInlineWriter(&buf, h)
+			if err != nil {
+				return nil, err
+			}
+			if err := writeAlternatives(w, text, html); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		}
+
+		inline := inlineBody(text, html)
+		h.SetContentType(inline.contentType, map[string]string{"charset": "utf-8"})
+		w, err := mail.CreateSingleInlineWriter(&buf, h)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeAndClose(w, []byte(inline.content)); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw, err := mail.CreateWriter(&buf, h)
+	if err != nil {
+		return nil, err
+	}
+
+	if text != "" && html != "" {
+		iw, err := mw.CreateInline()
+		if err != nil {
+			return nil, err
+		}
+		if err := writeAlternatives(iw, text, html); err != nil {
+			return nil, err
+		}
+	} else if text != "" || html != "" {
+		inline := inlineBody(text, html)
+		var ih mail.InlineHeader
+		ih.SetContentType(inline.contentType, map[string]string{"charset": "utf-8"})
+		w, err := mw.CreateSingleInline(ih)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeAndClose(w, []byte(inline.content)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, a := range attachments {
+		var ah mail.AttachmentHeader
+		ah.SetContentType(a.contentType, a.params)
+		ah.SetFilename(a.filename)
+		if a.contentId != "" {
+			ah.Set("Content-Id", "<"+a.contentId+">")
+		}
+		w, err := mw.CreateAttachment(ah)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeAndClose(w, a.content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// inlineBody sceglie il corpo di un messaggio con una sola parte testuale (text/plain se entrambi mancano)
+func inlineBody(text, html string) builderPart {
+	if html != "" && text == "" {
+		return builderPart{"text/html", html}
+	}
+	return builderPart{"text/plain", text}
+}
+
+// writeAlternatives scrive text/plain e text/html come parti alternative
+func writeAlternatives(w *mail.InlineWriter, text, html string) error {
+	for _, part := range []builderPart{
+		{"text/plain", text},
+		{"text/html", html},
+	} {
+		var ih mail.InlineHeader
+		ih.SetContentType(part.contentType, map[string]string{"charset": "utf-8"})
+		pw, err := w.CreatePart(ih)
+		if err != nil {
+			return err
+		}
+		if err := writeAndClose(pw, []byte(part.content)); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// writeAndClose scrive il contenuto di una parte e la chiude (chiudendo la parte si completa la codifica)
+func writeAndClose(w io.WriteCloser, data []byte) error {
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// parseAddresses accetta una stringa con uno o più indirizzi, un oggetto { name, address } o un array di entrambi
+func parseAddresses(value interface{}, key string) ([]*mail.Address, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		addrs, err := mail.ParseAddressList(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", key, v, err)
+		}
+		return addrs, nil
+	case map[string]interface{}:
+		address, _ := v["address"].(string)
+		if address == "" {
+			return nil, fmt.Errorf("%s must have an address", key)
+		}
+		name, _ := v["name"].(string)
+		return []*mail.Address{{Name: name, Address: address}}, nil
+	case []interface{}:
+		var addrs []*mail.Address
+		for _, item := range v {
+			list, err := parseAddresses(item, key)
+			if err != nil {
+				return nil, err
+			}
+			addrs = append(addrs, list...)
+		}
+		return addrs, nil
+	}
+	return nil, fmt.Errorf("%s must be a string, an { name, address } object or an array", key)
+}
+
+// addBuilderHeaders aggiunge gli header personalizzati { nome: valore | [valori] }, codificati RFC 2047 se necessario
+func addBuilderHeaders(h *mail.Header, value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("headers must be an object")
+	}
+
+	for name := range obj {
+		values, err := optionStrings(obj, name, "headers."+name)
+		if err != nil {
+			return err
+		}
+		if len(values) == 0 {
+			continue
+		}
+		// Add antepone il campo a quelli con lo stesso nome: si aggiungono i valori al contrario per mantenerne l'ordine
+		h.Del(name)
+		for i := len(values) - 1; i >= 0; i-- {
+			h.Add(name, mime.QEncoding.Encode("utf-8", values[i]))
+		}
+	}
+	return nil
+}
+
+// parseBuilderAttachments converte l'array di allegati di BuildMessage
+func parseBuilderAttachments(value interface{}) ([]builderAttachment, error) {
+	if value == nil {
+		return nil, nil
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("attachments must be an array")
+	}
+
+	attachments := make([]builderAttachment, 0, len(list))
+	for i, item := range list {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("attachments[%d] must be an object", i)
+		}
+
+		var a builderAttachment
+		var err error
+		if a.filename, err = optionString(obj, "filename", fmt.Sprintf("attachments[%d].filename", i)); err != nil {
+			return nil, err
+		}
+		if a.contentType, err = optionString(obj, "contentType", fmt.Sprintf("attachments[%d].contentType", i)); err != nil {
+			return nil, err
+		}
+		if a.contentType == "" {
+			a.contentType = "application/octet-stream"
+		}
+		// Il content type può avere parametri, es. "text/csv; charset=utf-8"
+		if a.contentType, a.params, err = mime.ParseMediaType(a.contentType); err != nil {
+			return nil, fmt.Errorf("invalid attachments[%d].contentType: %v", i, err)
+		}
+		if a.contentId, err = optionString(obj, "contentId", fmt.Sprintf("attachments[%d].contentId", i)); err != nil {
+			return nil, err
+		}
+
+		switch content := obj["content"].(type) {
+		case string:
+			a.content = []byte(content)
+		case []byte:
+			a.content = content
+		case sobek.ArrayBuffer:
+			a.content = content.Bytes()
+		default:
+			return nil, fmt.Errorf("attachments[%d].content must be a string or an ArrayBuffer", i)
+		}
+
+		attachments = append(attachments, a)
+	}
+
+	return attachments, nil
+}
@@ -0,0 +1,250 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// builtPart è una parte di un messaggio composto da BuildMessage, riletta con go-message
+type builtPart struct {
+	contentType string
+	filename    string
+	encoding    string
+	content     string
+}
+
+// readBuiltMessage rilegge un messaggio con go-message restituendone l'header e le parti in ordine
+func readBuiltMessage(t *testing.T, data []byte) (*mail.Reader, []builtPart) {
+	t.Helper()
+
+	mr, err := mail.CreateReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("CreateReader: %v", err)
+	}
+
+	var parts []builtPart
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("NextPart: %v", err)
+		}
+		body, err := io.ReadAll(p.Body)
+		if err != nil {
+			t.Fatalf("reading part: %v", err)
+		}
+
+		var part builtPart
+		switch h := p.Header.(type) {
+		case *mail.InlineHeader:
+			part.contentType, _, _ = h.ContentType()
+			part.encoding = h.Get("Content-Transfer-Encoding")
+		case *mail.AttachmentHeader:
+			part.contentType, _, _ = h.ContentType()
+			part.filename, _ = h.Filename()
+			part.encoding = h.Get("Content-Transfer-Encoding")
+		}
+		part.content = string(body)
+		parts = append(parts, part)
+	}
+	return mr, parts
+}
+
+func TestBuildMessageRoundTrip(t *testing.T) {
+	date := time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)
+	// Abbastanza lungo da richiedere più righe base64
+	csv := strings.Repeat("numero;importo;descrizione àèìòù\r\n", 10)
+
+	data, err := BuildMessage(map[string]interface{}{
+		"from":      map[string]interface{}{"name": "Ufficio Fatturazione", "address": "billing@acme.com"},
+		"to":        "Mario Rossi <mario@example.com>, anna@example.com",
+		"cc":        []interface{}{"ops@example.com", map[string]interface{}{"address": "audit@example.com"}},
+		"subject":   "Fattura n° 42 – marzo",
+		"date":      date,
+		"messageId": "fattura-42@acme.com",
+		"headers":   map[string]interface{}{"X-Campaign": []interface{}{"k6", "città"}},
+		"text":      "Gentile cliente, in allegato la fattura.",
+		"html":      "<p>Gentile cliente, in allegato la <b>fattura</b>.</p>",
+		"attachments": []interface{}{
+			map[string]interface{}{"filename": "fattura-42.csv", "contentType": "text/csv; charset=utf-8", "content": csv},
+			map[string]interface{}{"filename": "logo.png", "contentType": "image/png", "content": []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}, "contentId": "logo"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+
+	// Il messaggio deve essere ASCII puro: header RFC 2047, testo quoted-printable, allegati base64
+	for i, b := range data {
+		if b >= 0x80 {
+			t.Fatalf("non-ASCII byte 0x%x at offset %d", b, i)
+		}
+	}
+	raw := string(data)
+	if !strings.Contains(raw, "Subject: =?utf-8?") {
+		t.Errorf("subject is not RFC 2047 encoded:\n%s", raw)
+	}
+
+	mr, parts := readBuiltMessage(t, data)
+
+	mediaType, params, err := mr.Header.ContentType()
+	if err != nil || mediaType != "multipart/mixed" || params["boundary"] == "" {
+		t.Fatalf("Content-Type = %q %v (%v), want multipart/mixed with a boundary", mediaType, params, err)
+	}
+	if !strings.Contains(raw, "\r\n--"+params["boundary"]+"--\r\n") {
+		t.Errorf("closing boundary %q not found", params["boundary"])
+	}
+
+	if subject, err := mr.Header.Subject(); err != nil || subject != "Fattura n° 42 – marzo" {
+		t.Errorf("Subject = %q (%v)", subject, err)
+	}
+	if got, err := mr.Header.Date(); err != nil || !got.Equal(date) {
+		t.Errorf("Date = %v (%v), want %v", got, err, date)
+	}
+	if id, err := mr.Header.MessageID(); err != nil || id != "fattura-42@acme.com" {
+		t.Errorf("Message-Id = %q (%v)", id, err)
+	}
+	if got := mr.Header.Values("X-Campaign"); len(got) != 2 {
+		t.Errorf("X-Campaign = %q, want 2 values", got)
+	} else if value, err := mr.Header.Text("X-Campaign"); err != nil || value != "k6" {
+		t.Errorf("first X-Campaign = %q (%v), want k6", value, err)
+	}
+
+	for _, field := range []struct {
+		header string
+		want   []string
+	}{
+		{"From", []string{`"Ufficio Fatturazione" <billing@acme.com>`}},
+		{"To", []string{`"Mario Rossi" <mario@example.com>`, "<anna@example.com>"}},
+		{"Cc", []string{"<ops@example.com>", "<audit@example.com>"}},
+	} {
+		addrs, err := mr.Header.AddressList(field.header)
+		if err != nil {
+			t.Errorf("%s: %v", field.header, err)
+			continue
+		}
+		var got []string
+		for _, a := range addrs {
+			got = append(got, a.String())
+		}
+		if strings.Join(got, ", ") != strings.Join(field.want, ", ") {
+			t.Errorf("%s = %q, want %q", field.header, got, field.want)
+		}
+	}
+
+	want := []builtPart{
+		{contentType: "text/plain", encoding: "quoted-printable", content: "Gentile cliente, in allegato la fattura."},
+		{contentType: "text/html", encoding: "quoted-printable", content: "<p>Gentile cliente, in allegato la <b>fattura</b>.</p>"},
+		{contentType: "text/csv", filename: "fattura-42.csv", encoding: "base64", content: csv},
+		{contentType: "image/png", filename: "logo.png", encoding: "base64", content: "\x89PNG\x00\xff"},
+	}
+	if len(parts) != len(want) {
+		t.Fatalf("got %d parts %+v, want %d", len(parts), parts, len(want))
+	}
+	for i := range want {
+		if parts[i] != want[i] {
+			t.Errorf("part %d = %+v, want %+v", i, parts[i], want[i])
+		}
+	}
+
+	if !strings.Contains(raw, "Content-Type: multipart/alternative") {
+		t.Errorf("text and html are not multipart/alternative:\n%s", raw)
+	}
+	if !strings.Contains(raw, "Content-Id: <logo>") {
+		t.Errorf("Content-Id of the inline image not found:\n%s", raw)
+	}
+}
+
+func TestBuildMessageSinglePart(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        map[string]interface{}
+		contentType string
+		content     string
+	}{
+		{
+			name:        "text only",
+			spec:        map[string]interface{}{"text": "Caffè alle 10"},
+			contentType: "text/plain",
+			content:     "Caffè alle 10",
+		},
+		{
+			name:        "html only",
+			spec:        map[string]interface{}{"html": "<p>Ciao</p>"},
+			contentType: "text/html",
+			content:     "<p>Ciao</p>",
+		},
+		{
+			name:        "empty body",
+			spec:        map[string]interface{}{},
+			contentType: "text/plain",
+			content:     "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.spec["from"] = "billing@acme.com"
+			data, err := BuildMessage(tt.spec)
+			if err != nil {
+				t.Fatalf("BuildMessage: %v", err)
+			}
+
+			mr, parts := readBuiltMessage(t, data)
+			if _, err := mr.Header.MessageID(); err != nil {
+				t.Errorf("Message-Id: %v", err)
+			}
+			if _, err := mr.Header.Date(); err != nil {
+				t.Errorf("Date: %v", err)
+			}
+			if len(parts) != 1 {
+				t.Fatalf("got %d parts, want 1", len(parts))
+			}
+			if parts[0].contentType != tt.contentType || parts[0].content != tt.content {
+				t.Errorf("part = %+v, want %s %q", parts[0], tt.contentType, tt.content)
+			}
+		})
+	}
+}
+
+func TestBuildMessageErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		spec map[string]interface{}
+		want string
+	}{
+		{"invalid address", map[string]interface{}{"to": "not an address"}, "invalid to"},
+		{"object without address", map[string]interface{}{"from": map[string]interface{}{"name": "Acme"}}, "from must have an address"},
+		{"address of wrong type", map[string]interface{}{"cc": int64(1)}, "cc must be a string"},
+		{"invalid date", map[string]interface{}{"date": "yesterday"}, "date must be a Date"},
+		{"headers not an object", map[string]interface{}{"headers": "X-A: 1"}, "headers must be an object"},
+		{"attachments not an array", map[string]interface{}{"attachments": "file.pdf"}, "attachments must be an array"},
+		{"attachment not an object", map[string]interface{}{"attachments": []interface{}{"file.pdf"}}, "attachments[0] must be an object"},
+		{
+			"attachment without content",
+			map[string]interface{}{"attachments": []interface{}{map[string]interface{}{"filename": "a.pdf"}}},
+			"attachments[0].content must be",
+		},
+		{
+			"invalid content type",
+			map[string]interface{}{"attachments": []interface{}{map[string]interface{}{"contentType": "text/", "content": "x"}}},
+			"invalid attachments[0].contentType",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := BuildMessage(tt.spec)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -1,36 +1,42 @@
 package client
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"mime/quotedprintable"
 	"net/textproto"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
 	"github.com/grafana/sobek"
 	"go.k6.io/k6/js/modules"
-	"go.k6.io/k6/js/promises"
 )
 
 type EmailClient struct {
-	Vu         modules.VU
-	Email      string
-	Password   string
-	Url        string
-	Port       int
-	client     *client.Client
-	cancelChan chan struct{} // Canale per interrompere WaitNewEmail
+	Vu       modules.VU
+	Email    string
+	Password string
+	Url      string
+	Port     int
+	Options  Options
+	client   *client.Client
+	waits    waitSet // Attese di WaitNewEmail in corso, annullabili una per una (vedi waits.go)
+
+	watch mailboxWatch // EXISTS ricevuti dal server e IDLE condiviso tra le attese (vedi idle.go)
+
+	Metrics *Metrics     // Metriche k6, nil per non emetterle
+	counter *byteCounter // Traffico della connessione non ancora attribuito a un comando
+
+	conn connLock // Serializza i comandi tra thread JavaScript e WaitNewEmail (vedi lock.go)
 }
 
 // convertJSObjectToMIMEHeader converte un oggetto JavaScript in textproto.MIMEHeader
 // Gestisce la conversione da map[string]interface{} (come viene passato da k6) a textproto.MIMEHeader
 func convertJSObjectToMIMEHeader(obj map[string]interface{}) textproto.MIMEHeader {
 	header := make(textproto.MIMEHeader)
-	
+
 	for key, value := range obj {
 		switch v := value.(type) {
 		case []interface{}:
@@ -52,483 +58,490 @@ func convertJSObjectToMIMEHeader(obj map[string]interface{}) textproto.MIMEHeade
 			header[key] = v
 		}
 	}
-	
+
 	return header
 }
 
 // messageToMap converte un *imap.Message in un map[string]interface{} compatibile con k6/JavaScript
-func messageToMap(msg *imap.Message) (map[string]interface{}, error) {
+// uidValidity è quello della mailbox selezionata: insieme all'UID identifica il messaggio in modo stabile
+func messageToMap(msg *imap.Message, uidValidity uint32) map[string]interface{} {
 	result := make(map[string]interface{})
-	
+
 	// Subject
 	if msg.Envelope != nil {
 		result["subject"] = msg.Envelope.Subject
-		
-		// From
-		if len(msg.Envelope.From) > 0 {
-			fromAddrs := make([]string, 0, len(msg.Envelope.From))
-			for _, addr := range msg.Envelope.From {
-				if addr.MailboxName != "" && addr.HostName != "" {
-					fromAddrs = append(fromAddrs, fmt.Sprintf("%s@%s", addr.MailboxName, addr.HostName))
-				} else if addr.MailboxName != "" {
-					fromAddrs = append(fromAddrs, addr.MailboxName)
-				}
-			}
-			if len(fromAddrs) == 1 {
-				result["from"] = fromAddrs[0]
-			} else {
-				result["from"] = fromAddrs
-			}
-		}
-		
-		// To
-		if len(msg.Envelope.To) > 0 {
-			toAddrs := make([]string, 0, len(msg.Envelope.To))
-			for _, addr := range msg.Envelope.To {
-				if addr.MailboxName != "" && addr.HostName != "" {
-					toAddrs = append(toAddrs, fmt.Sprintf("%s@%s", addr.MailboxName, addr.HostName))
-				} else if addr.MailboxName != "" {
-					toAddrs = append(toAddrs, addr.MailboxName)
-				}
-			}
-			result["to"] = toAddrs
-		}
-		
-		// Cc
-		if len(msg.Envelope.Cc) > 0 {
-			ccAddrs := make([]string, 0, len(msg.Envelope.Cc))
-			for _, addr := range msg.Envelope.Cc {
-				if addr.MailboxName != "" && addr.HostName != "" {
-					ccAddrs = append(ccAddrs, fmt.Sprintf("%s@%s", addr.MailboxName, addr.HostName))
-				} else if addr.MailboxName != "" {
-					ccAddrs = append(ccAddrs, addr.MailboxName)
-				}
-			}
-			result["cc"] = ccAddrs
-		}
-		
-		// Bcc
-		if len(msg.Envelope.Bcc) > 0 {
-			bccAddrs := make([]string, 0, len(msg.Envelope.Bcc))
-			for _, addr := range msg.Envelope.Bcc {
-				if addr.MailboxName != "" && addr.HostName != "" {
-					bccAddrs = append(bccAddrs, fmt.Sprintf("%s@%s", addr.MailboxName, addr.HostName))
-				} else if addr.MailboxName != "" {
-					bccAddrs = append(bccAddrs, addr.MailboxName)
-				}
-			}
-			result["bcc"] = bccAddrs
-		}
-		
+
+		// Indirizzi: sempre array di { name, address, mailbox, host }
+		result["from"] = addressesToList(msg.Envelope.From)
+		result["to"] = addressesToList(msg.Envelope.To)
+		result["cc"] = addressesToList(msg.Envelope.Cc)
+		result["bcc"] = addressesToList(msg.Envelope.Bcc)
+		result["replyTo"] = addressesToList(msg.Envelope.ReplyTo)
+		result["sender"] = addressesToList(msg.Envelope.Sender)
+
 		// Date (data di invio dal mittente)
 		if !msg.Envelope.Date.IsZero() {
 			result["date"] = msg.Envelope.Date.Format(time.RFC3339)
 			result["dateTimestamp"] = msg.Envelope.Date.Unix()
 		}
 	}
-	
+
+	// Flags e dimensione (se richiesti nel fetch)
+	if msg.Flags != nil {
+		result["flags"] = msg.Flags
+	}
+	if msg.Size > 0 {
+		result["size"] = msg.Size
+	}
+
 	// InternalDate (data di arrivo sul server)
 	if !msg.InternalDate.IsZero() {
 		result["internalDate"] = msg.InternalDate.Format(time.RFC3339)
 		result["internalDateTimestamp"] = msg.InternalDate.Unix()
 	}
-	
-	// Body
-	section, _ := imap.ParseBodySectionName("BODY[TEXT]")
-	r := msg.GetBody(section)
-	if r != nil {
-		qr := quotedprintable.NewReader(r)
-		bs, err := ioutil.ReadAll(qr)
-		if err == nil {
-			result["body"] = string(bs)
+
+	// Body: parti text/plain e text/html decodificate (scaricate da fetchTextParts)
+	// body resta per compatibilità: è il testo semplice o, se manca, l'HTML
+	// Una parte che non si riesce a decodificare resta grezza e l'errore è riportato in structure (decodeError)
+	if msg.BodyStructure != nil {
+		decodeErrors := make(map[string]string)
+		text, html := textParts(msg.BodyStructure)
+		if text != nil {
+			s, ok, err := decodePartBody(msg, text)
+			if err != nil {
+				decodeErrors[text.section] = err.Error()
+			}
+			if ok {
+				result["text"] = s
+				result["body"] = s
+			}
 		}
-	}
-	
-	// Headers (se disponibili)
-	if len(msg.Body) > 0 {
-		// Prova a recuperare gli headers dal body
-		headerSection, _ := imap.ParseBodySectionName("BODY[HEADER]")
-		headerReader := msg.GetBody(headerSection)
-		if headerReader != nil {
-			headerBytes, err := ioutil.ReadAll(headerReader)
-			if err == nil {
-				headers := make(map[string]interface{})
-				headerText := string(headerBytes)
-				lines := strings.Split(headerText, "\r\n")
-				var currentKey string
-				var currentValues []string
-				
-				for _, line := range lines {
-					line = strings.TrimRight(line, "\r\n")
-					if line == "" {
-						// Fine degli headers
-						break
-					}
-					if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
-						// Continuazione della riga precedente
-						if currentKey != "" && len(currentValues) > 0 {
-							currentValues[len(currentValues)-1] += " " + strings.TrimSpace(line)
-						}
-					} else if strings.Contains(line, ":") {
-						// Salva l'header precedente
-						if currentKey != "" {
-							existingValue, exists := headers[currentKey]
-							if exists {
-								// Header già presente, aggiungi ai valori esistenti
-								if existingArray, ok := existingValue.([]string); ok {
-									headers[currentKey] = append(existingArray, currentValues...)
-								} else if existingStr, ok := existingValue.(string); ok {
-									headers[currentKey] = []string{existingStr, currentValues[0]}
-								}
-							} else {
-								// Nuovo header
-								if len(currentValues) == 1 {
-									headers[currentKey] = currentValues[0]
-								} else {
-									headers[currentKey] = currentValues
-								}
-							}
-						}
-						// Nuovo header
-						parts := strings.SplitN(line, ":", 2)
-						if len(parts) == 2 {
-							currentKey = strings.TrimSpace(strings.ToLower(parts[0]))
-							currentValues = []string{strings.TrimSpace(parts[1])}
-						} else {
-							currentKey = ""
-							currentValues = nil
-						}
-					}
-				}
-				// Aggiungi l'ultimo header
-				if currentKey != "" {
-					if len(currentValues) == 1 {
-						headers[currentKey] = currentValues[0]
-					} else {
-						headers[currentKey] = currentValues
-					}
-				}
-				if len(headers) > 0 {
-					result["headers"] = headers
+		if html != nil {
+			s, ok, err := decodePartBody(msg, html)
+			if err != nil {
+				decodeErrors[html.section] = err.Error()
+			}
+			if ok {
+				result["html"] = s
+				if _, exists := result["body"]; !exists {
+					result["body"] = s
 				}
 			}
 		}
+		result["structure"] = structureToMap(msg.BodyStructure, nil, decodeErrors)
+		result["attachments"] = attachmentsToMap(msg.BodyStructure)
 	}
-	
-	// Message ID (UID)
-	result["uid"] = msg.SeqNum
-	
-	return result, nil
+
+	// Headers (se disponibili)
+	headerSection, _ := imap.ParseBodySectionName("BODY[HEADER]")
+	if headerReader := msg.GetBody(headerSection); headerReader != nil {
+		headersToMap(result, parseHeader(headerReader))
+	}
+
+	// UID (stabile finché UIDVALIDITY non cambia) e numero di sequenza (cambia dopo ogni expunge)
+	result["uid"] = msg.Uid
+	result["uidValidity"] = uidValidity
+	result["seqNum"] = msg.SeqNum
+
+	return result
 }
 
-func (e *EmailClient) Login() string {
-	c, err := client.DialTLS(e.Url+":"+strconv.Itoa(e.Port), nil)
+func (e *EmailClient) Login() error {
+	return e.login(e.Options.Auth.RefreshToken)
+}
 
-	if err != nil {
-		return err.Error()
-	}
+// login apre la connessione e si autentica; refresh è la callback di rinnovo del token (vedi authenticate)
+func (e *EmailClient) login(refresh func() (string, error)) error {
+	e.lock()
+	defer e.unlock()
 
-	e.client = c
+	e.counter = &byteCounter{}
 
-	err = e.client.Login(e.Email, e.Password)
+	var c *client.Client
+	err := e.track("connect", "", func() error {
+		var err error
+		c, err = dial(e.Url, e.Port, e.Options, e.counter)
+		return err
+	})
 
 	if err != nil {
-		return err.Error()
+		return err
 	}
 
-	return ""
+	// Le risposte non richieste (EXISTS/RECENT) servono a WaitNewEmail in modalità IDLE
+	updates := make(chan client.Update, 16)
+	c.Updates = updates
+	go e.dispatchUpdates(c, updates)
+
+	e.client = c
 
+	return e.track("login", "", func() error {
+		return e.authenticate(refresh)
+	})
 }
 
-func (e *EmailClient) Read(headerObj map[string]interface{}) (map[string]interface{}, string) {
-	fmt.Println("Read called with headerObj:", headerObj)
-	
+// Read restituisce l'email più recente che corrisponde ai criteri (vedi parseSearchCriteria)
+// readOpts è facoltativo: { mailbox } sceglie la mailbox (default quella delle opzioni del client o INBOX)
+func (e *EmailClient) Read(criteriaObj map[string]interface{}, readOpts map[string]interface{}) (map[string]interface{}, error) {
+	fmt.Println("Read called with criteriaObj:", criteriaObj)
+
+	e.lock()
+	defer e.unlock()
+
 	// Verifica che il client sia connesso
 	if e.client == nil {
-		return nil, "Client not connected. Call login() first."
+		return nil, notConnected()
 	}
-	
-	_, err := e.client.Select("INBOX", true)
+
+	mailbox, err := e.mailboxOption(readOpts)
 	if err != nil {
-		fmt.Printf("Error selecting INBOX: %v\n", err)
-		return nil, err.Error()
+		return nil, err
 	}
 
-	// Converti l'oggetto JavaScript in textproto.MIMEHeader
-	header := convertJSObjectToMIMEHeader(headerObj)
-	fmt.Printf("Converted header: %+v\n", header)
+	// Converti l'oggetto JavaScript in imap.SearchCriteria
+	criteria, err := parseSearchCriteria(criteriaObj)
+	if err != nil {
+		return nil, err
+	}
 
-	criteria := &imap.SearchCriteria{
-		Header: header,
+	_, err = e.selectMailbox(mailbox, true)
+	if err != nil {
+		fmt.Printf("Error selecting %s: %v\n", mailbox, err)
+		return nil, err
 	}
 
-	ids, err := e.client.Search(criteria)
+	ids, err := e.search(criteria)
 	if err != nil {
 		fmt.Printf("Error searching: %v\n", err)
-		return nil, err.Error()
+		return nil, err
 	}
 
 	fmt.Printf("Found %d message IDs\n", len(ids))
 
 	if len(ids) == 0 {
-		return nil, "No messages found"
+		return nil, newError(ErrCodeNotFound, "No messages found")
 	}
 
-	// Prendi solo il primo messaggio (il più recente, ultimo ID)
+	// Prendi solo il primo messaggio (il più recente, UID più alto)
 	latestID := ids[len(ids)-1]
-	seqSet := new(imap.SeqSet)
-	seqSet.AddNum(latestID)
-
-	// Recupera ENVELOPE (subject, from, to, date) e BODY[TEXT] (body)
-	items := []imap.FetchItem{
-		imap.FetchItem("ENVELOPE"),
-		imap.FetchItem("BODY[TEXT]"),
-		imap.FetchItem("BODY[HEADER]"),
-	}
-	messages := make(chan *imap.Message, 1)
+	uidSet := new(imap.SeqSet)
+	uidSet.AddNum(latestID)
 
+	// Recupera ENVELOPE (subject, from, to, date), BODYSTRUCTURE e le parti testuali (body)
 	fmt.Printf("Fetching message ID %d...\n", latestID)
-	err = e.client.Fetch(seqSet, items, messages)
+	messages, err := e.fetchFull(uidSet)
 	if err != nil {
 		fmt.Printf("Error fetching: %v\n", err)
-		return nil, err.Error()
+		return nil, err
+	}
+
+	if len(messages) == 0 {
+		return nil, newError(ErrCodeNotFound, "No message")
+	}
+	msg := messages[0]
+
+	emailMap := messageToMap(msg, e.uidValidity())
+
+	fmt.Println("Read successful")
+	return emailMap, nil
+}
+
+// ReadFirstText restituisce il BODY[TEXT] del primo messaggio (il più vecchio) che corrisponde ai criteri,
+// decodificato quoted-printable: è il risultato storico di Imap.read, che non passa da Read
+func ReadFirstText(e *EmailClient, criteriaObj map[string]interface{}) (string, error) {
+	e.lock()
+	defer e.unlock()
+
+	if e.client == nil {
+		return "", notConnected()
 	}
 
-	fmt.Println("Fetch completed, reading from channel...")
-	msg := <-messages
-	fmt.Println("Message received from channel")
-	
-	if msg == nil {
-		return nil, "No message"
+	mailbox, err := e.mailboxOption(nil)
+	if err != nil {
+		return "", err
 	}
 
-	emailMap, err := messageToMap(msg)
+	criteria, err := parseSearchCriteria(criteriaObj)
 	if err != nil {
-		fmt.Printf("Error converting message to map: %v\n", err)
-		return nil, err.Error()
+		return "", err
 	}
 
-	fmt.Println("Read successful")
-	return emailMap, ""
+	if _, err := e.selectMailbox(mailbox, true); err != nil {
+		return "", err
+	}
+
+	ids, err := e.search(criteria)
+	if err != nil {
+		return "", err
+	}
+	if len(ids) == 0 {
+		return "", newError(ErrCodeNotFound, "No messages found")
+	}
+
+	uidSet := new(imap.SeqSet)
+	uidSet.AddNum(ids[0])
+
+	section, _ := imap.ParseBodySectionName("BODY[TEXT]")
+	messages, err := e.fetch(uidSet, []imap.FetchItem{section.FetchItem()})
+	if err != nil {
+		return "", err
+	}
+	if len(messages) == 0 {
+		return "", newError(ErrCodeNotFound, "No message")
+	}
+
+	r := messages[0].GetBody(section)
+	if r == nil {
+		return "", newError(ErrCodeNotFound, "Could not get message body")
+	}
+
+	body, err := io.ReadAll(quotedprintable.NewReader(r))
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
 }
 
-func (e *EmailClient) WaitNewEmail(headerObj map[string]interface{}, timeoutMs int64) *sobek.Promise {
+// WaitNewEmail attende una nuova email che corrisponde ai criteri, arrivata dopo la chiamata
+// waitOpts è facoltativo: { mailbox, sentAt }, dove sentAt è l'orario di invio (Date o millisecondi) usato per imap_delivery_latency
+// La promise viene risolta sull'event loop del VU (vedi async) e tiene viva l'iterazione finché l'attesa non finisce
+// (email trovata, timeout o annullamento); è rifiutata prima solo se k6 interrompe il VU (gracefulStop scaduto o test interrotto)
+// Più attese possono essere in corso sullo stesso client; per annullarne una sola usare StartWait
+func (e *EmailClient) WaitNewEmail(criteriaObj map[string]interface{}, timeoutMs int64, waitOpts map[string]interface{}) *sobek.Promise {
+	_, promise := e.startWait(criteriaObj, timeoutMs, waitOpts)
+	return promise
+}
+
+// StartWait è come WaitNewEmail ma restituisce un handle { id, promise, cancel } per annullare solo questa attesa
+// cancel() rifiuta la promise e restituisce false se l'attesa era già terminata
+// Usage da JavaScript:
+// const invoice = client.startWait({ subject: "Invoice" }, 30000)
+// const receipt = client.startWait({ subject: "Receipt" }, 30000)
+// const email = await invoice.promise
+// receipt.cancel()
+func (e *EmailClient) StartWait(criteriaObj map[string]interface{}, timeoutMs int64, waitOpts map[string]interface{}) map[string]interface{} {
+	id, promise := e.startWait(criteriaObj, timeoutMs, waitOpts)
+	return map[string]interface{}{
+		"id":      id,
+		"promise": promise,
+		"cancel": func() bool {
+			return id != 0 && e.waits.cancelWait(id)
+		},
+	}
+}
+
+// CancelAllWaits annulla tutte le attese in corso e restituisce quante erano
+func (e *EmailClient) CancelAllWaits() int {
+	return e.waits.cancelAll()
+}
+
+// startWait avvia un'attesa e ne restituisce l'id (0 se la promise è già rifiutata per parametri non validi)
+func (e *EmailClient) startWait(criteriaObj map[string]interface{}, timeoutMs int64, waitOpts map[string]interface{}) (uint64, *sobek.Promise) {
 	// Verifica che il VU sia disponibile
 	if e.Vu == nil {
 		panic("VU context not available. EmailClient must be created inside the default function, not in init context.")
 	}
-	
-	promise, resolve, reject := promises.New(e.Vu)
-	
-	// Verifica che il client sia connesso
-	if e.client == nil {
-		reject(fmt.Errorf("Client not connected. Call login() first."))
-		return promise
-	}
-	
-	// Crea un nuovo canale di cancellazione per questa promise
-	e.cancelChan = make(chan struct{})
-	cancelChan := e.cancelChan
-	
-	go func() {
-		fmt.Println("WaitNewEmail started, timeout:", timeoutMs, "ms")
+
+	mailbox, err := e.mailboxOption(waitOpts)
+	if err != nil {
+		return 0, e.rejected(err)
+	}
+
+	sentAt, err := parseDateOption(waitOpts["sentAt"], "sentAt")
+	if err != nil {
+		return 0, e.rejected(err)
+	}
+
+	// Converti l'oggetto JavaScript in imap.SearchCriteria
+	criteria, err := parseSearchCriteria(criteriaObj)
+	if err != nil {
+		return 0, e.rejected(err)
+	}
+
+	// Ogni attesa ha il suo canale di cancellazione
+	id, cancelChan := e.waits.add()
+
+	return id, e.async(func(ctx context.Context) (interface{}, error) {
+		defer e.waits.done(id)
+
+		fmt.Println("WaitNewEmail", id, "started, timeout:", timeoutMs, "ms")
 		startTime := time.Now()
 		// Sottrai 1 secondo per evitare problemi di precisione con il server IMAP
 		searchSince := startTime.Add(-1 * time.Second)
-		timeoutDuration := time.Duration(timeoutMs) * time.Millisecond
-		
-		// Converti l'oggetto JavaScript in textproto.MIMEHeader
-		header := convertJSObjectToMIMEHeader(headerObj)
-		
-		// Polling ogni 2 secondi
-		pollInterval := 2 * time.Second
+		timeout := time.After(time.Duration(timeoutMs) * time.Millisecond)
+
+		// Usa IDLE (RFC 2177) se il server lo annuncia, altrimenti polling ogni 2 secondi
+		// e.client viene assegnato da login, anche da LoginAsync in un'altra goroutine: va letto con la connessione acquisita
+		e.lock()
+		if e.client == nil {
+			e.unlock()
+			return nil, notConnected()
+		}
+		useIdle, err := e.client.Support("IDLE")
+		e.unlock()
+		if err != nil {
+			return nil, err
+		}
+
 		iteration := 0
-		
+
 		// Set di message ID già controllati e non validi (da skippare)
 		skippedIDs := make(map[uint32]bool)
-		
+
 		for {
-			// Controlla se la promise è stata interrotta
-			select {
-			case <-cancelChan:
-				fmt.Printf("WaitNewEmail cancelled after %d iterations\n", iteration)
-				reject(fmt.Errorf("WaitNewEmail was cancelled"))
-				return
-			default:
-			}
-			
 			iteration++
-			elapsed := time.Since(startTime)
-			
-			// Controlla se il timeout è scaduto
-			if elapsed >= timeoutDuration {
-				fmt.Printf("WaitNewEmail timeout after %d iterations, elapsed: %v\n", iteration, elapsed)
-				reject(fmt.Errorf("Timeout: no new email found within %d ms", timeoutMs))
-				return
-			}
-			
-			fmt.Printf("WaitNewEmail iteration %d, elapsed: %v\n", iteration, elapsed)
-			
-			// Seleziona la mailbox
-			_, err := e.client.Select("INBOX", true)
-			if err != nil {
-				fmt.Printf("Error selecting INBOX: %v\n", err)
-				reject(err)
-				return
-			}
-			
-			// Crea i criteri di ricerca con Since per filtrare solo email nuove
-			// Since usa la "Internal date" (data di arrivo sul server)
-			criteria := &imap.SearchCriteria{
-				Header: header,
-				Since:  searchSince,
-			}
-			
-			ids, err := e.client.Search(criteria)
+			fmt.Printf("WaitNewEmail iteration %d, elapsed: %v\n", iteration, time.Since(startTime))
+
+			// Ogni controllo tiene la connessione solo per il tempo di SELECT, SEARCH e FETCH
+			e.lock()
+			emailMap, seen, err := e.checkNewEmail(mailbox, criteria, searchSince, startTime, skippedIDs)
+			e.unlock()
 			if err != nil {
-				fmt.Printf("Error searching: %v\n", err)
-				reject(err)
-				return
+				return nil, err
 			}
-			
-			fmt.Printf("Found %d emails matching criteria (with Since filter)\n", len(ids))
-			
-			// Se troviamo email, controlla solo l'ultima (più recente)
-			// perché quelle precedenti non ci servono
-			if len(ids) > 0 {
-				// Prendi solo l'ultimo ID (il più recente, dato che sono ordinati crescente)
-				latestID := ids[len(ids)-1]
-				
-				// Se questo ID è già stato controllato e non era valido, skippalo
-				if skippedIDs[latestID] {
-					fmt.Printf("Skipping message ID %d (already checked and not valid)\n", latestID)
-					select {
-					case <-cancelChan:
-						fmt.Printf("WaitNewEmail cancelled after %d iterations\n", iteration)
-						reject(fmt.Errorf("WaitNewEmail was cancelled"))
-						return
-					case <-time.After(pollInterval):
-						// Continua il loop
-					}
-					continue
-				}
-				
-				seqSet := new(imap.SeqSet)
-				seqSet.AddNum(latestID)
-				
-				// Recupera ENVELOPE, INTERNALDATE, BODY[TEXT] e BODY[HEADER]
-				items := []imap.FetchItem{
-					imap.FetchItem("ENVELOPE"),
-					imap.FetchItem("INTERNALDATE"),
-					imap.FetchItem("BODY[TEXT]"),
-					imap.FetchItem("BODY[HEADER]"),
-				}
-				messages := make(chan *imap.Message, 1)
-				
-				fmt.Printf("Fetching latest message ID %d to check date...\n", latestID)
-				err = e.client.Fetch(seqSet, items, messages)
-				if err != nil {
-					fmt.Printf("Error fetching message ID %d: %v\n", latestID, err)
-					// Continua il polling se c'è un errore nel fetch
-					select {
-					case <-cancelChan:
-						fmt.Printf("WaitNewEmail cancelled after %d iterations\n", iteration)
-						reject(fmt.Errorf("WaitNewEmail was cancelled"))
-						return
-					case <-time.After(pollInterval):
-						// Continua il loop
-					}
-					continue
-				}
-				
-				msg := <-messages
-				if msg == nil {
-					fmt.Printf("Message ID %d is nil\n", latestID)
-					// Aggiungi l'ID al set di skipped perché non è valido
-					skippedIDs[latestID] = true
-					// Continua il polling se il messaggio è nil
-					select {
-					case <-cancelChan:
-						fmt.Printf("WaitNewEmail cancelled after %d iterations\n", iteration)
-						reject(fmt.Errorf("WaitNewEmail was cancelled"))
-						return
-					case <-time.After(pollInterval):
-						// Continua il loop
-					}
-					continue
-				}
-				
-				// Verifica che la data interna (data di arrivo sul server) sia successiva a startTime
-				if !msg.InternalDate.IsZero() {
-					fmt.Printf("Message ID %d InternalDate: %v, startTime: %v, after: %v\n", 
-						latestID, msg.InternalDate, startTime, msg.InternalDate.After(startTime))
-					
-					if msg.InternalDate.After(startTime) {
-						// Questa è una nuova email, convertila in oggetto strutturato
-						fmt.Printf("Found new email with ID %d\n", latestID)
-						
-						emailMap, err := messageToMap(msg)
-						if err != nil {
-							fmt.Printf("Error converting message to map: %v\n", err)
-							reject(err)
-							return
-						}
-						
-						fmt.Printf("WaitNewEmail success after %d iterations\n", iteration)
-						resolve(emailMap)
-						return
-					} else {
-						// La data non è valida, aggiungi l'ID al set di skipped
-						fmt.Printf("Message ID %d is not new (InternalDate not after startTime), adding to skipped list\n", latestID)
-						skippedIDs[latestID] = true
-					}
-				} else {
-					fmt.Printf("Message ID %d has no InternalDate\n", latestID)
-					// Aggiungi l'ID al set di skipped perché non ha InternalDate
-					skippedIDs[latestID] = true
+			if emailMap != nil {
+				observed := time.Now()
+				var arrival time.Time
+				if timestamp, ok := emailMap["internalDateTimestamp"].(int64); ok {
+					arrival = time.Unix(timestamp, 0)
 				}
+				e.recordDeliveryLatency(emailMap, mailbox, arrival, observed, sentAt)
+
+				fmt.Printf("WaitNewEmail success after %d iterations\n", iteration)
+				return emailMap, nil
 			}
-			
-			// Aspetta prima del prossimo polling con controllo di cancellazione
-			// fmt.Printf("No new emails found, waiting %v before next check\n", pollInterval)
-			select {
-			case <-cancelChan:
-				fmt.Printf("WaitNewEmail cancelled during sleep after %d iterations\n", iteration)
-				reject(fmt.Errorf("WaitNewEmail was cancelled"))
-				return
-			case <-time.After(pollInterval):
+
+			// Aspetta il prossimo cambiamento della mailbox (o il prossimo polling)
+			err = e.waitForChange(ctx, mailbox, seen, useIdle, cancelChan, timeout)
+			switch err {
+			case nil:
 				// Continua il loop
+			case errWaitCancelled:
+				fmt.Printf("WaitNewEmail cancelled after %d iterations\n", iteration)
+				return nil, err
+			case errWaitTimeout:
+				fmt.Printf("WaitNewEmail timeout after %d iterations, elapsed: %v\n", iteration, time.Since(startTime))
+				return nil, newError(ErrCodeTimeout, "Timeout: no new email found within %d ms", timeoutMs)
+			default:
+				return nil, err
 			}
 		}
-	}()
-	
-	return promise
+	})
+}
+
+// checkNewEmail cerca l'email più recente che corrisponde ai criteri ed è arrivata dopo startTime
+// Restituisce nil se non c'è ancora nessuna email nuova; l'errore è valorizzato solo per errori fatali
+// seen è il numero di messaggi della mailbox al momento della ricerca, usato da waitForChange
+func (e *EmailClient) checkNewEmail(mailbox string, criteria *imap.SearchCriteria, searchSince, startTime time.Time, skippedIDs map[uint32]bool) (emailMap map[string]interface{}, seen uint32, err error) {
+	// Seleziona la mailbox
+	status, err := e.selectMailbox(mailbox, true)
+	if err != nil {
+		fmt.Printf("Error selecting %s: %v\n", mailbox, err)
+		return nil, 0, err
+	}
+	seen = status.Messages
+
+	// Aggiungi ai criteri Since per filtrare solo email nuove
+	// Since usa la "Internal date" (data di arrivo sul server)
+	ids, err := e.search(withSince(criteria, searchSince))
+	if err != nil {
+		fmt.Printf("Error searching: %v\n", err)
+		return nil, 0, err
+	}
+
+	fmt.Printf("Found %d emails matching criteria (with Since filter)\n", len(ids))
+
+	// Se troviamo email, controlla solo l'ultima (più recente)
+	// perché quelle precedenti non ci servono
+	if len(ids) == 0 {
+		return nil, seen, nil
+	}
+
+	// Prendi solo l'ultimo UID (il più recente, dato che sono ordinati crescente)
+	latestID := ids[len(ids)-1]
+
+	// Se questo ID è già stato controllato e non era valido, skippalo
+	if skippedIDs[latestID] {
+		fmt.Printf("Skipping message ID %d (already checked and not valid)\n", latestID)
+		return nil, seen, nil
+	}
+
+	uidSet := new(imap.SeqSet)
+	uidSet.AddNum(latestID)
+
+	// Recupera ENVELOPE, INTERNALDATE, BODYSTRUCTURE, le parti testuali e BODY[HEADER]
+	fmt.Printf("Fetching latest message ID %d to check date...\n", latestID)
+	messages, err := e.fetchFull(uidSet)
+	if err != nil {
+		// Continua il polling se c'è un errore nel fetch
+		fmt.Printf("Error fetching message ID %d: %v\n", latestID, err)
+		return nil, seen, nil
+	}
+
+	if len(messages) == 0 {
+		fmt.Printf("Message ID %d is nil\n", latestID)
+		// Aggiungi l'ID al set di skipped perché non è valido
+		skippedIDs[latestID] = true
+		return nil, seen, nil
+	}
+	msg := messages[0]
+
+	// Verifica che la data interna (data di arrivo sul server) sia successiva a startTime
+	if msg.InternalDate.IsZero() {
+		fmt.Printf("Message ID %d has no InternalDate\n", latestID)
+		// Aggiungi l'ID al set di skipped perché non ha InternalDate
+		skippedIDs[latestID] = true
+		return nil, seen, nil
+	}
+
+	fmt.Printf("Message ID %d InternalDate: %v, startTime: %v, after: %v\n",
+		latestID, msg.InternalDate, startTime, msg.InternalDate.After(startTime))
+
+	if !msg.InternalDate.After(startTime) {
+		// La data non è valida, aggiungi l'ID al set di skipped
+		fmt.Printf("Message ID %d is not new (InternalDate not after startTime), adding to skipped list\n", latestID)
+		skippedIDs[latestID] = true
+		return nil, seen, nil
+	}
+
+	// Questa è una nuova email, convertila in oggetto strutturato
+	fmt.Printf("Found new email with ID %d\n", latestID)
+
+	return messageToMap(msg, e.uidValidity()), seen, nil
 }
 
-// killCurrentWaitNewMailPromise interrompe la promise corrente di WaitNewEmail se attiva
+// killCurrentWaitNewMailPromise interrompe l'ultima promise di WaitNewEmail ancora attiva
+// Con più attese in corso usare StartWait per annullarne una precisa, o CancelAllWaits
 func (e *EmailClient) KillCurrentWaitNewMailPromise() {
-	if e.cancelChan != nil {
-		close(e.cancelChan)
-		e.cancelChan = nil
+	if e.waits.cancelLatest() {
 		fmt.Println("WaitNewEmail promise cancelled")
 	}
 }
 
 // DeleteEmailsOlderThan elimina tutte le email più vecchie della data specificata
 // La data viene confrontata con InternalDate (data di arrivo sul server)
-// Restituisce il numero di email eliminate e un eventuale errore come stringa
+// Restituisce il numero di email eliminate e un eventuale errore
 // beforeTimestampUnix è un timestamp Unix in secondi (int64)
+// deleteOpts è facoltativo: { mailbox } sceglie la mailbox (default quella delle opzioni del client o INBOX)
 // Usage da JavaScript: client.DeleteEmailsOlderThan(Math.floor(Date.now() / 1000) - 86400) // 24 ore fa
-func (e *EmailClient) DeleteEmailsOlderThan(beforeTimestampUnix int64) (int, string) {
+func (e *EmailClient) DeleteEmailsOlderThan(beforeTimestampUnix int64, deleteOpts map[string]interface{}) (int, error) {
+	e.lock()
+	defer e.unlock()
+
 	// Verifica che il client sia connesso
 	if e.client == nil {
-		return 0, "client not connected. Call login() first"
+		return 0, notConnected()
 	}
 
-	// Seleziona la mailbox INBOX in modalità read-write (false) per permettere l'eliminazione
-	_, err := e.client.Select("INBOX", false)
+	mailbox, err := e.mailboxOption(deleteOpts)
 	if err != nil {
-		return 0, fmt.Sprintf("error selecting INBOX: %v", err)
+		return 0, err
+	}
+
+	// Seleziona la mailbox in modalità read-write (false) per permettere l'eliminazione
+	_, err = e.selectMailbox(mailbox, false)
+	if err != nil {
+		return 0, fmt.Errorf("error selecting %s: %w", mailbox, err)
 	}
 
 	// Converti il timestamp Unix in time.Time
@@ -540,38 +553,39 @@ func (e *EmailClient) DeleteEmailsOlderThan(beforeTimestampUnix int64) (int, str
 		Before: beforeDate,
 	}
 
-	ids, err := e.client.Search(criteria)
+	ids, err := e.search(criteria)
 	if err != nil {
-		return 0, fmt.Sprintf("error searching emails: %v", err)
+		return 0, fmt.Errorf("error searching emails: %w", err)
 	}
 
 	if len(ids) == 0 {
-		return 0, "" // Nessuna email da eliminare
+		return 0, nil // Nessuna email da eliminare
 	}
 
-	// Crea un SeqSet con tutti gli ID trovati
-	seqSet := new(imap.SeqSet)
-	seqSet.AddNum(ids...)
+	// Crea un SeqSet con tutti gli UID trovati
+	uidSet := new(imap.SeqSet)
+	uidSet.AddNum(ids...)
 
 	// Marca le email come cancellate usando il flag \Deleted
-	item := imap.FormatFlagsOp(imap.AddFlags, true)
-	flags := []interface{}{imap.DeletedFlag}
-	err = e.client.Store(seqSet, item, flags, nil)
+	err = e.store(uidSet, imap.AddFlags, []string{imap.DeletedFlag})
 	if err != nil {
-		return 0, fmt.Sprintf("error marking emails as deleted: %v", err)
+		return 0, fmt.Errorf("error marking emails as deleted: %w", err)
 	}
 
-	// Rimuovi definitivamente le email marcate come cancellate
-	err = e.client.Expunge(nil)
+	// Rimuovi definitivamente solo le email appena marcate (UID EXPUNGE se il server supporta UIDPLUS)
+	err = e.expunge(uidSet)
 	if err != nil {
-		return 0, fmt.Sprintf("error expunging emails: %v", err)
+		return 0, fmt.Errorf("error expunging emails: %w", err)
 	}
 
-	return len(ids), ""
+	return len(ids), nil
 }
 
 func (e *EmailClient) Logout() {
+	e.lock()
+	defer e.unlock()
+
 	if e.client != nil {
-		e.client.Logout()
+		e.track("logout", "", e.client.Logout)
 	}
 }
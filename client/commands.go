@@ -0,0 +1,319 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+)
+
+// I metodi di questo file avvolgono i comandi di go-imap registrando le metriche k6
+// Ricerca, fetch e store usano sempre gli UID: i numeri di sequenza cambiano dopo ogni expunge
+// I comandi sono inviati con execute invece dei metodi di client.Client, che scartano il response code
+// delle risposte NO/BAD (es. [NONEXISTENT] o [AUTHENTICATIONFAILED]) necessario a ImapError
+
+// execute invia un comando e converte una risposta NO/BAD in errore conservandone response code e argomenti
+func (e *EmailClient) execute(cmd imap.Commander, res responses.Handler) (*imap.StatusResp, error) {
+	status, err := e.client.Execute(cmd, res)
+	if err != nil {
+		return nil, err
+	}
+	return status, statusError(status)
+}
+
+// selectMailbox seleziona la mailbox indicata
+// Come client.Select, la mailbox viene registrata nel client prima del comando per ricevere le risposte non richieste
+func (e *EmailClient) selectMailbox(name string, readOnly bool) (*imap.MailboxStatus, error) {
+	mbox := &imap.MailboxStatus{Name: name, Items: make(map[imap.StatusItem]interface{})}
+	err := e.track("select", name, func() error {
+		state := e.client.State()
+		e.client.SetState(state, mbox)
+
+		status, err := e.execute(&commands.Select{Mailbox: name, ReadOnly: readOnly}, &responses.Select{Mailbox: mbox})
+		if err != nil {
+			e.client.SetState(state, nil)
+			return err
+		}
+
+		mbox.ReadOnly = status.Code == imap.CodeReadOnly
+		e.client.SetState(imap.SelectedState, mbox)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mbox, nil
+}
+
+// uidValidity restituisce UIDVALIDITY della mailbox selezionata (0 se nessuna)
+func (e *EmailClient) uidValidity() uint32 {
+	if status := e.client.Mailbox(); status != nil {
+		return status.UidValidity
+	}
+	return 0
+}
+
+// search esegue UID SEARCH sulla mailbox selezionata e restituisce gli UID
+// Come client.UidSearch usa il charset UTF-8 e ripete la ricerca in US-ASCII se il server risponde BADCHARSET
+func (e *EmailClient) search(criteria *imap.SearchCriteria) ([]uint32, error) {
+	var uids []uint32
+	err := e.track("search", "", func() error {
+		var status *imap.StatusResp
+		var err error
+		uids, status, err = e.uidSearch(criteria, "UTF-8")
+		if status != nil && status.Code == imap.CodeBadCharset {
+			uids, _, err = e.uidSearch(criteria, "US-ASCII")
+		}
+		return err
+	})
+	return uids, err
+}
+
+// uidSearch invia UID SEARCH con il charset indicato
+func (e *EmailClient) uidSearch(criteria *imap.SearchCriteria, charset string) ([]uint32, *imap.StatusResp, error) {
+	res := new(responses.Search)
+	status, err := e.execute(&commands.Uid{Cmd: &commands.Search{Charset: charset, Criteria: criteria}}, res)
+	return res.Ids, status, err
+}
+
+// fetch esegue UID FETCH e restituisce tutti i messaggi ricevuti
+func (e *EmailClient) fetch(uidSet *imap.SeqSet, items []imap.FetchItem) ([]*imap.Message, error) {
+	var result []*imap.Message
+	err := e.track("fetch", "", func() error {
+		var err error
+		result, err = e.executeMessages(&commands.Fetch{SeqSet: uidSet, Items: items}, uidSet)
+		return err
+	})
+	return result, err
+}
+
+// executeMessages esegue come comando UID un FETCH o uno STORE e raccoglie le risposte FETCH ricevute
+func (e *EmailClient) executeMessages(cmd imap.Commander, uidSet *imap.SeqSet) ([]*imap.Message, error) {
+	var result []*imap.Message
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+	go func() {
+		defer close(messages)
+		_, err := e.execute(&commands.Uid{Cmd: cmd}, &responses.Fetch{Messages: messages, SeqSet: uidSet, Uid: true})
+		done <- err
+	}()
+	for msg := range messages {
+		result = append(result, msg)
+	}
+	return result, <-done
+}
+
+// fetchFull recupera i messaggi completi (fullItems) insieme alle loro parti testuali
+func (e *EmailClient) fetchFull(uidSet *imap.SeqSet) ([]*imap.Message, error) {
+	messages, err := e.fetch(uidSet, fullItems)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.fetchTextParts(messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// store modifica con UID STORE i flag dei messaggi indicati, senza chiedere i flag risultanti (FLAGS.SILENT)
+func (e *EmailClient) store(uidSet *imap.SeqSet, op imap.FlagsOp, flags []string) error {
+	return e.track("store", "", func() error {
+		_, err := e.execute(&commands.Uid{Cmd: &commands.Store{
+			SeqSet: uidSet,
+			Item:   imap.FormatFlagsOp(op, true),
+			Value:  flagValues(flags),
+		}}, nil)
+		return err
+	})
+}
+
+// storeFlags modifica con UID STORE i flag dei messaggi indicati e restituisce i flag risultanti
+func (e *EmailClient) storeFlags(uidSet *imap.SeqSet, op imap.FlagsOp, flags []string) ([]*imap.Message, error) {
+	var result []*imap.Message
+	err := e.track("store", "", func() error {
+		var err error
+		result, err = e.executeMessages(&commands.Store{
+			SeqSet: uidSet,
+			Item:   imap.FormatFlagsOp(op, false),
+			Value:  flagValues(flags),
+		}, uidSet)
+		return err
+	})
+	return result, err
+}
+
+// flagValues converte i flag in atomi IMAP: come stringhe verrebbero inviati tra virgolette
+func flagValues(flags []string) []interface{} {
+	values := make([]interface{}, len(flags))
+	for i, flag := range flags {
+		values[i] = imap.RawString(flag)
+	}
+	return values
+}
+
+// copyMessages copia con UID COPY i messaggi indicati nella mailbox dest
+func (e *EmailClient) copyMessages(uidSet *imap.SeqSet, dest string) error {
+	return e.track("copy", "", func() error {
+		_, err := e.execute(&commands.Uid{Cmd: &commands.Copy{SeqSet: uidSet, Mailbox: dest}}, nil)
+		return err
+	})
+}
+
+// moveMessages sposta i messaggi indicati nella mailbox dest con UID MOVE (RFC 6851) se il server lo annuncia,
+// altrimenti con UID COPY, UID STORE +FLAGS (\Deleted) ed EXPUNGE
+// Senza UIDPLUS il fallback controlla prima della COPY che expunge potrà procedere (vedi expunge),
+// così un move rifiutato non lascia i messaggi in entrambe le mailbox
+func (e *EmailClient) moveMessages(uidSet *imap.SeqSet, dest string) error {
+	supported, err := e.client.Support("MOVE")
+	if err != nil {
+		return err
+	}
+
+	if supported {
+		return e.track("move", "", func() error {
+			_, err := e.execute(&commands.Uid{Cmd: &commands.Move{SeqSet: uidSet, Mailbox: dest}}, nil)
+			return err
+		})
+	}
+
+	uidPlus, err := e.client.Support("UIDPLUS")
+	if err != nil {
+		return err
+	}
+	if !uidPlus {
+		others, err := e.otherDeleted(uidSet)
+		if err != nil {
+			return err
+		}
+		if len(others) > 0 {
+			return newError(ErrCodeProtocol, "server supports neither MOVE nor UIDPLUS and %d other messages are marked \\Deleted: not moving", len(others))
+		}
+	}
+
+	if err := e.copyMessages(uidSet, dest); err != nil {
+		return err
+	}
+	if err := e.store(uidSet, imap.AddFlags, []string{imap.DeletedFlag}); err != nil {
+		return err
+	}
+	return e.expunge(uidSet)
+}
+
+// uidExpunge è il comando EXPUNGE con un set di UID, da usare dentro UID (UIDPLUS, RFC 4315)
+// go-imap non lo implementa
+type uidExpunge struct {
+	uidSet *imap.SeqSet
+}
+
+func (cmd *uidExpunge) Command() *imap.Command {
+	return &imap.Command{
+		Name:      "EXPUNGE",
+		Arguments: []interface{}{cmd.uidSet},
+	}
+}
+
+// expunge rimuove definitivamente i messaggi indicati, già marcati \Deleted
+// Con UIDPLUS usa UID EXPUNGE e non tocca gli altri messaggi \Deleted della mailbox (ad esempio quelli di altri VU)
+// Senza UIDPLUS usa EXPUNGE solo se nella mailbox non ci sono altri messaggi \Deleted: altrimenti
+// toglie \Deleted dai messaggi indicati e restituisce un errore invece di eliminare anche gli altri
+func (e *EmailClient) expunge(uidSet *imap.SeqSet) error {
+	uidPlus, err := e.client.Support("UIDPLUS")
+	if err != nil {
+		return err
+	}
+
+	if uidPlus {
+		return e.track("expunge", "", func() error {
+			_, err := e.execute(&commands.Uid{Cmd: &uidExpunge{uidSet: uidSet}}, nil)
+			return err
+		})
+	}
+
+	others, err := e.otherDeleted(uidSet)
+	if err != nil {
+		return err
+	}
+
+	if len(others) > 0 {
+		err := newError(ErrCodeProtocol, "server does not support UIDPLUS and %d other messages are marked \\Deleted: not expunging", len(others))
+		if restoreErr := e.store(uidSet, imap.RemoveFlags, []string{imap.DeletedFlag}); restoreErr != nil {
+			return newError(ErrCodeProtocol, "%v (removing \\Deleted failed: %v)", err, restoreErr)
+		}
+		return err
+	}
+
+	return e.track("expunge", "", func() error {
+		_, err := e.execute(&commands.Expunge{}, nil)
+		return err
+	})
+}
+
+// otherDeleted cerca i messaggi della mailbox marcati \Deleted oltre a quelli indicati, che un EXPUNGE eliminerebbe
+func (e *EmailClient) otherDeleted(uidSet *imap.SeqSet) ([]uint32, error) {
+	return e.search(&imap.SearchCriteria{
+		WithFlags: []string{imap.DeletedFlag},
+		Not:       []*imap.SearchCriteria{{Uid: uidSet}},
+	})
+}
+
+// FetchByUid recupera il messaggio con l'UID indicato, completo di body e header
+// fetchOpts è facoltativo: { mailbox, uidValidity }; se uidValidity è indicato e la mailbox
+// è stata ricreata nel frattempo (UIDVALIDITY diverso) viene restituito un errore
+// Usage da JavaScript: const [message, err] = client.fetchByUid(saved.uid, { uidValidity: saved.uidValidity })
+func (e *EmailClient) FetchByUid(uid int64, fetchOpts map[string]interface{}) (map[string]interface{}, error) {
+	e.lock()
+	defer e.unlock()
+
+	if e.client == nil {
+		return nil, notConnected()
+	}
+
+	if uid <= 0 || uid > int64(^uint32(0)) {
+		return nil, fmt.Errorf("invalid UID %d", uid)
+	}
+
+	status, err := e.selectForUid(fetchOpts, true)
+	if err != nil {
+		return nil, err
+	}
+
+	uidSet := new(imap.SeqSet)
+	uidSet.AddNum(uint32(uid))
+
+	messages, err := e.fetchFull(uidSet)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(messages) == 0 {
+		return nil, newError(ErrCodeNotFound, "No message with UID %d", uid)
+	}
+
+	return messageToMap(messages[0], status.UidValidity), nil
+}
+
+// selectForUid seleziona la mailbox delle opzioni { mailbox, uidValidity }
+// Se uidValidity è indicato e la mailbox è stata ricreata nel frattempo (UIDVALIDITY diverso) restituisce un errore
+func (e *EmailClient) selectForUid(opts map[string]interface{}, readOnly bool) (*imap.MailboxStatus, error) {
+	mailbox, err := e.mailboxOption(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	expectedValidity, err := optionCount(opts, "uidValidity")
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := e.selectMailbox(mailbox, readOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	if expectedValidity != 0 && uint32(expectedValidity) != status.UidValidity {
+		return nil, fmt.Errorf("UIDVALIDITY of %s changed from %d to %d", mailbox, expectedValidity, status.UidValidity)
+	}
+
+	return status, nil
+}
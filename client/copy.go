@@ -0,0 +1,59 @@
+package client
+
+import "errors"
+
+// Copy copia i messaggi con gli UID indicati nella mailbox dest, lasciando gli originali al loro posto
+// uids accetta un set IMAP come "1:100,200", un singolo UID o un array di UID
+// copyOpts è facoltativo: { mailbox, uidValidity } indica la mailbox di origine, come in FetchByUid
+// Usage da JavaScript: const err = client.copy(message.uid, "Archive")
+func (e *EmailClient) Copy(uids interface{}, dest string, copyOpts map[string]interface{}) error {
+	e.lock()
+	defer e.unlock()
+
+	if e.client == nil {
+		return notConnected()
+	}
+
+	if dest == "" {
+		return errors.New("destination mailbox must not be empty")
+	}
+
+	uidSet, err := parseSeqSet(uids, "uids")
+	if err != nil {
+		return err
+	}
+
+	if _, err := e.selectForUid(copyOpts, true); err != nil {
+		return err
+	}
+
+	return e.copyMessages(uidSet, dest)
+}
+
+// Move sposta i messaggi con gli UID indicati nella mailbox dest
+// Usa MOVE (RFC 6851) se il server lo annuncia, altrimenti COPY seguito da \Deleted ed EXPUNGE
+// moveOpts è facoltativo: { mailbox, uidValidity } indica la mailbox di origine, come in FetchByUid
+// Usage da JavaScript: const err = client.move(message.uid, "Archive")
+func (e *EmailClient) Move(uids interface{}, dest string, moveOpts map[string]interface{}) error {
+	e.lock()
+	defer e.unlock()
+
+	if e.client == nil {
+		return notConnected()
+	}
+
+	if dest == "" {
+		return errors.New("destination mailbox must not be empty")
+	}
+
+	uidSet, err := parseSeqSet(uids, "uids")
+	if err != nil {
+		return err
+	}
+
+	if _, err := e.selectForUid(moveOpts, false); err != nil {
+		return err
+	}
+
+	return e.moveMessages(uidSet, dest)
+}
@@ -0,0 +1,301 @@
+package client
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// criteriaFlags associa le chiavi booleane dei criteri ai flag IMAP
+// true cerca i messaggi con il flag, false quelli senza
+var criteriaFlags = map[string]string{
+	"seen":     imap.SeenFlag,
+	"answered": imap.AnsweredFlag,
+	"flagged":  imap.FlaggedFlag,
+	"deleted":  imap.DeletedFlag,
+	"draft":    imap.DraftFlag,
+}
+
+// criteriaHeaders associa le chiavi abbreviate dei criteri agli header da cercare
+var criteriaHeaders = map[string]string{
+	"from":    "From",
+	"to":      "To",
+	"cc":      "Cc",
+	"bcc":     "Bcc",
+	"subject": "Subject",
+}
+
+// parseSearchCriteria converte l'oggetto JavaScript dei criteri in imap.SearchCriteria
+//
+// Chiavi supportate:
+//   - from, to, cc, bcc, subject: stringa o array di stringhe
+//   - header: oggetto { NomeHeader: valore o array di valori }
+//   - body, text: stringa o array di stringhe
+//   - since, before (data di arrivo), sentSince, sentBefore (header Date):
+//     Date, stringa ISO 8601 o timestamp in millisecondi (come Date.now())
+//   - seen, answered, flagged, deleted, draft: booleani; unseen: true equivale a seen: false
+//   - keywords, unkeywords: stringa o array di keyword
+//   - larger, smaller: dimensione in byte
+//   - uid: "1:100,200", un numero o un array di numeri
+//   - not: criteri (o array di criteri) da escludere
+//   - or: array di almeno due criteri alternativi
+//   - all: true non aggiunge condizioni, ma rende esplicito che i criteri vuoti corrispondono a tutti i messaggi
+//
+// Le altre chiavi sono trattate come nomi di header, come in { Subject: ["Verify your email"] }
+func parseSearchCriteria(obj map[string]interface{}) (*imap.SearchCriteria, error) {
+	criteria := imap.NewSearchCriteria()
+
+	// Le chiavi non riconosciute vengono cercate come header
+	unknown := make(map[string]interface{})
+
+	for key, value := range obj {
+		if value == nil {
+			continue
+		}
+
+		if header, ok := criteriaHeaders[key]; ok {
+			values, err := criteriaStrings(value, key)
+			if err != nil {
+				return nil, err
+			}
+			for _, v := range values {
+				criteria.Header.Add(header, v)
+			}
+			continue
+		}
+
+		if flag, ok := criteriaFlags[key]; ok {
+			b, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("criteria %s must be a boolean", key)
+			}
+			if b {
+				criteria.WithFlags = append(criteria.WithFlags, flag)
+			} else {
+				criteria.WithoutFlags = append(criteria.WithoutFlags, flag)
+			}
+			continue
+		}
+
+		var err error
+		switch key {
+		case "header":
+			headerObj, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("criteria header must be an object")
+			}
+			for name, values := range convertJSObjectToMIMEHeader(headerObj) {
+				criteria.Header[name] = append(criteria.Header[name], values...)
+			}
+		case "body":
+			criteria.Body, err = criteriaStrings(value, key)
+		case "text":
+			criteria.Text, err = criteriaStrings(value, key)
+		case "since":
+			criteria.Since, err = criteriaDate(value, key)
+		case "before":
+			criteria.Before, err = criteriaDate(value, key)
+		case "sentSince":
+			criteria.SentSince, err = criteriaDate(value, key)
+		case "sentBefore":
+			criteria.SentBefore, err = criteriaDate(value, key)
+		case "unseen":
+			b, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("criteria unseen must be a boolean")
+			}
+			if b {
+				criteria.WithoutFlags = append(criteria.WithoutFlags, imap.SeenFlag)
+			} else {
+				criteria.WithFlags = append(criteria.WithFlags, imap.SeenFlag)
+			}
+		case "keywords":
+			var keywords []string
+			keywords, err = criteriaStrings(value, key)
+			criteria.WithFlags = append(criteria.WithFlags, keywords...)
+		case "unkeywords":
+			var keywords []string
+			keywords, err = criteriaStrings(value, key)
+			criteria.WithoutFlags = append(criteria.WithoutFlags, keywords...)
+		case "larger":
+			criteria.Larger, err = criteriaSize(value, key)
+		case "smaller":
+			criteria.Smaller, err = criteriaSize(value, key)
+		case "uid":
+			criteria.Uid, err = parseSeqSet(value, key)
+		case "all":
+			if _, ok := value.(bool); !ok {
+				return nil, fmt.Errorf("criteria all must be a boolean")
+			}
+		case "not":
+			criteria.Not, err = criteriaList(value, key)
+		case "or":
+			var alternatives []*imap.SearchCriteria
+			if alternatives, err = criteriaList(value, key); err == nil {
+				if len(alternatives) < 2 {
+					return nil, fmt.Errorf("criteria or requires at least two alternatives")
+				}
+				criteria.Or = append(criteria.Or, orCriteria(alternatives))
+			}
+		default:
+			unknown[key] = value
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for name, values := range convertJSObjectToMIMEHeader(unknown) {
+		criteria.Header[name] = append(criteria.Header[name], values...)
+	}
+
+	return criteria, nil
+}
+
+// orCriteria combina più alternative in OR annidati: OR a (OR b c)
+func orCriteria(alternatives []*imap.SearchCriteria) [2]*imap.SearchCriteria {
+	if len(alternatives) == 2 {
+		return [2]*imap.SearchCriteria{alternatives[0], alternatives[1]}
+	}
+	rest := imap.NewSearchCriteria()
+	rest.Or = [][2]*imap.SearchCriteria{orCriteria(alternatives[1:])}
+	return [2]*imap.SearchCriteria{alternatives[0], rest}
+}
+
+// criteriaList converte un oggetto o un array di oggetti in criteri annidati
+func criteriaList(value interface{}, key string) ([]*imap.SearchCriteria, error) {
+	var objects []interface{}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		objects = []interface{}{v}
+	case []interface{}:
+		objects = v
+	default:
+		return nil, fmt.Errorf("criteria %s must be an object or an array of objects", key)
+	}
+
+	list := make([]*imap.SearchCriteria, 0, len(objects))
+	for _, item := range objects {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("criteria %s must be an object or an array of objects", key)
+		}
+		criteria, err := parseSearchCriteria(obj)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, criteria)
+	}
+	return list, nil
+}
+
+// criteriaStrings accetta una stringa o un array di stringhe
+func criteriaStrings(value interface{}, key string) ([]string, error) {
+	values, err := optionStrings(map[string]interface{}{key: value}, key, "criteria "+key)
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// criteriaDate accetta una Date JavaScript, una stringa ISO 8601 (anche solo la data)
+// o un timestamp in millisecondi, come gli altri timestamp del modulo
+func criteriaDate(value interface{}, key string) (time.Time, error) {
+	if v, ok := value.(string); ok {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, nil
+		}
+		if t, err := time.Parse("2006-01-02", v); err == nil {
+			return t, nil
+		}
+	} else if t, err := parseDateOption(value, key); err == nil && !t.IsZero() {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("criteria %s must be a Date, an ISO 8601 string or a timestamp in milliseconds", key)
+}
+
+// criteriaSize accetta una dimensione in byte da 0 a 4294967295 (il massimo di SEARCH LARGER/SMALLER)
+// Valori fuori intervallo sono rifiutati invece di essere troncati in una ricerca diversa
+func criteriaSize(value interface{}, key string) (uint32, error) {
+	switch v := value.(type) {
+	case int64:
+		if v >= 0 && v <= math.MaxUint32 {
+			return uint32(v), nil
+		}
+	case float64:
+		if v >= 0 && v <= math.MaxUint32 {
+			return uint32(v), nil
+		}
+	}
+	return 0, fmt.Errorf("criteria %s must be a number from 0 to %d", key, uint32(math.MaxUint32))
+}
+
+// parseSeqSet accetta un set IMAP come "1:100,200", un singolo numero o un array di numeri
+// I numeri devono essere interi da 1 a 4294967295: go-imap tratta 0 come "*" (il messaggio più recente),
+// quindi 0, "*", i negativi e i decimali sono rifiutati invece di agire su un messaggio qualsiasi
+func parseSeqSet(value interface{}, key string) (*imap.SeqSet, error) {
+	seqSet := new(imap.SeqSet)
+
+	switch v := value.(type) {
+	case string:
+		set, err := imap.ParseSeqSet(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", key, v, err)
+		}
+		for _, seq := range set.Set {
+			if seq.Start == 0 || seq.Stop == 0 {
+				return nil, fmt.Errorf("invalid %s %q: * is not supported, use explicit numbers", key, v)
+			}
+		}
+		return set, nil
+	case int64, float64:
+		n, err := seqNumber(v, key)
+		if err != nil {
+			return nil, err
+		}
+		seqSet.AddNum(n)
+	case []interface{}:
+		for _, item := range v {
+			n, err := seqNumber(item, key)
+			if err != nil {
+				return nil, err
+			}
+			seqSet.AddNum(n)
+		}
+	default:
+		return nil, fmt.Errorf("%s must be a string, a number or an array of numbers", key)
+	}
+
+	if len(seqSet.Set) == 0 {
+		return nil, fmt.Errorf("%s must not be empty", key)
+	}
+	return seqSet, nil
+}
+
+// seqNumber converte un numero JavaScript in un numero di un set IMAP (da 1 a 4294967295)
+func seqNumber(value interface{}, key string) (uint32, error) {
+	switch n := value.(type) {
+	case int64:
+		if n >= 1 && n <= math.MaxUint32 {
+			return uint32(n), nil
+		}
+	case float64:
+		if n >= 1 && n <= math.MaxUint32 && n == math.Trunc(n) {
+			return uint32(n), nil
+		}
+	default:
+		return 0, fmt.Errorf("%s must be a string, a number or an array of numbers", key)
+	}
+	return 0, fmt.Errorf("invalid %s %v: must be an integer from 1 to %d", key, value, uint32(math.MaxUint32))
+}
+
+// withSince restituisce una copia dei criteri che esclude i messaggi arrivati prima di since
+func withSince(criteria *imap.SearchCriteria, since time.Time) *imap.SearchCriteria {
+	copied := *criteria
+	if copied.Since.IsZero() || copied.Since.Before(since) {
+		copied.Since = since
+	}
+	return &copied
+}
@@ -0,0 +1,344 @@
+package client
+
+import (
+	"math"
+	"net/textproto"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// newCriteria restituisce criteri vuoti come quelli di parseSearchCriteria, modificati da fn
+func newCriteria(fn func(c *imap.SearchCriteria)) *imap.SearchCriteria {
+	c := imap.NewSearchCriteria()
+	if fn != nil {
+		fn(c)
+	}
+	return c
+}
+
+// seqSetOf restituisce il set con i numeri indicati
+func seqSetOf(uids ...uint32) *imap.SeqSet {
+	set := new(imap.SeqSet)
+	set.AddNum(uids...)
+	return set
+}
+
+func TestParseSearchCriteria(t *testing.T) {
+	since := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		criteria map[string]interface{}
+		want     *imap.SearchCriteria
+	}{
+		{
+			name:     "empty",
+			criteria: map[string]interface{}{},
+			want:     newCriteria(nil),
+		},
+		{
+			name:     "all",
+			criteria: map[string]interface{}{"all": true},
+			want:     newCriteria(nil),
+		},
+		{
+			name:     "header shortcut",
+			criteria: map[string]interface{}{"subject": "Invoice"},
+			want: newCriteria(func(c *imap.SearchCriteria) {
+				c.Header = textproto.MIMEHeader{"Subject": {"Invoice"}}
+			}),
+		},
+		{
+			name:     "header shortcut with several values",
+			criteria: map[string]interface{}{"from": []interface{}{"billing@acme.com", "noreply@acme.com"}},
+			want: newCriteria(func(c *imap.SearchCriteria) {
+				c.Header = textproto.MIMEHeader{"From": {"billing@acme.com", "noreply@acme.com"}}
+			}),
+		},
+		{
+			name:     "legacy header key",
+			criteria: map[string]interface{}{"X-Campaign-Id": []interface{}{"k6"}},
+			want: newCriteria(func(c *imap.SearchCriteria) {
+				c.Header = textproto.MIMEHeader{"X-Campaign-Id": {"k6"}}
+			}),
+		},
+		{
+			name:     "header object",
+			criteria: map[string]interface{}{"header": map[string]interface{}{"X-Campaign-Id": "k6"}},
+			want: newCriteria(func(c *imap.SearchCriteria) {
+				c.Header = textproto.MIMEHeader{"X-Campaign-Id": {"k6"}}
+			}),
+		},
+		{
+			name:     "flags",
+			criteria: map[string]interface{}{"seen": false, "flagged": true},
+			want: newCriteria(func(c *imap.SearchCriteria) {
+				c.WithFlags = []string{imap.FlaggedFlag}
+				c.WithoutFlags = []string{imap.SeenFlag}
+			}),
+		},
+		{
+			name:     "unseen and keywords",
+			criteria: map[string]interface{}{"unseen": true, "keywords": "processed"},
+			want: newCriteria(func(c *imap.SearchCriteria) {
+				c.WithFlags = []string{"processed"}
+				c.WithoutFlags = []string{imap.SeenFlag}
+			}),
+		},
+		{
+			name:     "dates and sizes",
+			criteria: map[string]interface{}{"since": "2024-01-02", "larger": int64(1024)},
+			want: newCriteria(func(c *imap.SearchCriteria) {
+				c.Since = since
+				c.Larger = 1024
+			}),
+		},
+		{
+			name:     "uid",
+			criteria: map[string]interface{}{"uid": []interface{}{int64(3), int64(5)}},
+			want: newCriteria(func(c *imap.SearchCriteria) {
+				c.Uid = seqSetOf(3, 5)
+			}),
+		},
+		{
+			name:     "not object",
+			criteria: map[string]interface{}{"not": map[string]interface{}{"seen": true}},
+			want: newCriteria(func(c *imap.SearchCriteria) {
+				c.Not = []*imap.SearchCriteria{newCriteria(func(c *imap.SearchCriteria) {
+					c.WithFlags = []string{imap.SeenFlag}
+				})}
+			}),
+		},
+		{
+			name: "or with two alternatives",
+			criteria: map[string]interface{}{"or": []interface{}{
+				map[string]interface{}{"subject": "Invoice"},
+				map[string]interface{}{"subject": "Receipt"},
+			}},
+			want: newCriteria(func(c *imap.SearchCriteria) {
+				c.Or = [][2]*imap.SearchCriteria{{
+					newCriteria(func(c *imap.SearchCriteria) { c.Header = textproto.MIMEHeader{"Subject": {"Invoice"}} }),
+					newCriteria(func(c *imap.SearchCriteria) { c.Header = textproto.MIMEHeader{"Subject": {"Receipt"}} }),
+				}}
+			}),
+		},
+		{
+			name: "or with three alternatives and nested not",
+			criteria: map[string]interface{}{"or": []interface{}{
+				map[string]interface{}{"flagged": true},
+				map[string]interface{}{"not": map[string]interface{}{"seen": true}},
+				map[string]interface{}{"larger": int64(10)},
+			}},
+			want: newCriteria(func(c *imap.SearchCriteria) {
+				flagged := newCriteria(func(c *imap.SearchCriteria) { c.WithFlags = []string{imap.FlaggedFlag} })
+				notSeen := newCriteria(func(c *imap.SearchCriteria) {
+					c.Not = []*imap.SearchCriteria{newCriteria(func(c *imap.SearchCriteria) { c.WithFlags = []string{imap.SeenFlag} })}
+				})
+				larger := newCriteria(func(c *imap.SearchCriteria) { c.Larger = 10 })
+				c.Or = [][2]*imap.SearchCriteria{{
+					flagged,
+					newCriteria(func(c *imap.SearchCriteria) { c.Or = [][2]*imap.SearchCriteria{{notSeen, larger}} }),
+				}}
+			}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSearchCriteria(tt.criteria)
+			if err != nil {
+				t.Fatalf("parseSearchCriteria(%v) error: %v", tt.criteria, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSearchCriteria(%v) = %+v, want %+v", tt.criteria, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSearchCriteriaErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		criteria map[string]interface{}
+	}{
+		{"flag not boolean", map[string]interface{}{"seen": "yes"}},
+		{"all not boolean", map[string]interface{}{"all": "yes"}},
+		{"header not object", map[string]interface{}{"header": "X-Campaign-Id"}},
+		{"or with one alternative", map[string]interface{}{"or": []interface{}{map[string]interface{}{"seen": true}}}},
+		{"or not objects", map[string]interface{}{"or": []interface{}{"a", "b"}}},
+		{"invalid nested criteria", map[string]interface{}{"not": map[string]interface{}{"larger": int64(-1)}}},
+		{"invalid date", map[string]interface{}{"since": "yesterday"}},
+		{"size too large", map[string]interface{}{"larger": float64(5e9)}},
+		{"negative size", map[string]interface{}{"smaller": int64(-1)}},
+		{"invalid uid", map[string]interface{}{"uid": int64(0)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := parseSearchCriteria(tt.criteria); err == nil {
+				t.Errorf("parseSearchCriteria(%v) = %+v, want error", tt.criteria, got)
+			}
+		})
+	}
+}
+
+func TestCriteriaDate(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "date only", value: "2024-03-01", want: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "RFC 3339", value: "2024-03-01T10:30:00+02:00", want: time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC)},
+		{name: "Date", value: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), want: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)},
+		{name: "milliseconds", value: int64(1709287200000), want: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)},
+		{name: "float milliseconds", value: float64(1709287200000), want: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)},
+		{name: "invalid string", value: "01/03/2024", wantErr: true},
+		{name: "boolean", value: true, wantErr: true},
+		{name: "zero Date", value: time.Time{}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := criteriaDate(tt.value, "since")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("criteriaDate(%v) = %v, want error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("criteriaDate(%v) error: %v", tt.value, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("criteriaDate(%v) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCriteriaSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    uint32
+		wantErr bool
+	}{
+		{name: "zero", value: int64(0), want: 0},
+		{name: "integer", value: int64(1024), want: 1024},
+		{name: "float", value: float64(2048), want: 2048},
+		{name: "maximum", value: int64(4294967295), want: 4294967295},
+		{name: "float maximum", value: float64(4294967295), want: 4294967295},
+		{name: "negative", value: int64(-1), wantErr: true},
+		{name: "negative float", value: float64(-0.5), wantErr: true},
+		{name: "too large", value: int64(4294967296), wantErr: true},
+		{name: "too large float", value: float64(5e9), wantErr: true},
+		{name: "infinity", value: math.Inf(1), wantErr: true},
+		{name: "NaN", value: math.NaN(), wantErr: true},
+		{name: "string", value: "1024", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := criteriaSize(tt.value, "larger")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("criteriaSize(%v) = %d, want error", tt.value, got)
+				} else if code := toImapError(err).Code; code != ErrCodeInvalid {
+					t.Errorf("criteriaSize(%v) error code = %q, want %q", tt.value, code, ErrCodeInvalid)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("criteriaSize(%v) = %d, %v, want %d", tt.value, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSeqSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    string
+		wantErr bool
+	}{
+		{name: "single number", value: int64(42), want: "42"},
+		{name: "float number", value: float64(42), want: "42"},
+		{name: "maximum", value: int64(4294967295), want: "4294967295"},
+		{name: "array", value: []interface{}{int64(1), float64(2), int64(3), int64(7)}, want: "1:3,7"},
+		{name: "string set", value: "1:100,200", want: "1:100,200"},
+		{name: "zero", value: int64(0), wantErr: true},
+		{name: "negative", value: int64(-1), wantErr: true},
+		{name: "fractional", value: float64(1.5), wantErr: true},
+		{name: "too large", value: int64(4294967296), wantErr: true},
+		{name: "zero in array", value: []interface{}{int64(1), int64(0)}, wantErr: true},
+		{name: "string in array", value: []interface{}{"1"}, wantErr: true},
+		{name: "empty array", value: []interface{}{}, wantErr: true},
+		{name: "star", value: "*", wantErr: true},
+		{name: "range to star", value: "1:*", wantErr: true},
+		{name: "zero in string", value: "0", wantErr: true},
+		{name: "negative in string", value: "-1", wantErr: true},
+		{name: "empty string", value: "", wantErr: true},
+		{name: "boolean", value: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSeqSet(tt.value, "uids")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseSeqSet(%v) = %v, want error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSeqSet(%v) error: %v", tt.value, err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("parseSeqSet(%v) = %q, want %q", tt.value, got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDeleteWhereRejectsEmptyCriteria(t *testing.T) {
+	tests := []struct {
+		name     string
+		criteria map[string]interface{}
+		wantErr  bool
+	}{
+		{"no criteria", map[string]interface{}{}, true},
+		{"nil criteria", nil, true},
+		{"empty body", map[string]interface{}{"body": []interface{}{}}, true},
+		{"empty text", map[string]interface{}{"text": []interface{}{}}, true},
+		{"empty not", map[string]interface{}{"not": []interface{}{}}, true},
+		{"empty header values", map[string]interface{}{"X-Campaign-Id": []interface{}{}}, true},
+		{"or with an empty alternative", map[string]interface{}{"or": []interface{}{map[string]interface{}{}, map[string]interface{}{"seen": true}}}, true},
+		{"nested or with an empty alternative", map[string]interface{}{"or": []interface{}{
+			map[string]interface{}{"seen": true}, map[string]interface{}{"flagged": true}, map[string]interface{}{"text": []interface{}{}},
+		}}, true},
+		{"all not true", map[string]interface{}{"all": false}, true},
+		{"all", map[string]interface{}{"all": true}, false},
+		{"subject", map[string]interface{}{"subject": "k6 run"}, false},
+		{"not empty criteria", map[string]interface{}{"not": map[string]interface{}{}}, false},
+		{"or with both alternatives", map[string]interface{}{"or": []interface{}{map[string]interface{}{"seen": true}, map[string]interface{}{"flagged": true}}}, false},
+	}
+
+	e := &EmailClient{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := e.parseDeleteWhere(tt.criteria, nil)
+			if tt.wantErr && err != errDeleteAll {
+				t.Errorf("parseDeleteWhere(%v) error = %v, want errDeleteAll", tt.criteria, err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("parseDeleteWhere(%v) error = %v", tt.criteria, err)
+			}
+		})
+	}
+}
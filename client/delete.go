@@ -0,0 +1,113 @@
+package client
+
+import (
+	"errors"
+
+	"github.com/emersion/go-imap"
+)
+
+// errDeleteAll è restituito da DeleteWhere quando i criteri sono vuoti e corrisponderebbero a tutta la mailbox
+var errDeleteAll = errors.New("deleteWhere requires at least one criterion, pass { all: true } to delete every message")
+
+// deleteWhereArgs sono gli argomenti di DeleteWhere già convertiti, sul thread del VU
+type deleteWhereArgs struct {
+	criteria *imap.SearchCriteria
+	mailbox  string
+	dryRun   bool
+}
+
+// parseDeleteWhere converte criteri e opzioni di DeleteWhere
+// Criteri vuoti ({} o nessun argomento) corrisponderebbero a tutti i messaggi: sono accettati solo con { all: true }
+func (e *EmailClient) parseDeleteWhere(criteriaObj, deleteOpts map[string]interface{}) (deleteWhereArgs, error) {
+	var args deleteWhereArgs
+	var err error
+
+	if args.mailbox, err = e.mailboxOption(deleteOpts); err != nil {
+		return args, err
+	}
+
+	if args.dryRun, err = optionBool(deleteOpts, "dryRun", "dryRun"); err != nil {
+		return args, err
+	}
+
+	if args.criteria, err = parseSearchCriteria(criteriaObj); err != nil {
+		return args, err
+	}
+
+	if all, _ := criteriaObj["all"].(bool); !all && isEmptyCriteria(args.criteria) {
+		return args, errDeleteAll
+	}
+
+	return args, nil
+}
+
+// isEmptyCriteria indica se i criteri corrispondono a tutti i messaggi: nessuna condizione (SEARCH ALL),
+// anche quando sono stati passati array vuoti come { body: [] } o { not: [] }, o un OR con un ramo vuoto
+func isEmptyCriteria(criteria *imap.SearchCriteria) bool {
+	rest := *criteria
+	rest.Or = nil
+	if fields := rest.Format(); len(fields) != 1 || fields[0] != imap.RawString("ALL") {
+		return false
+	}
+
+	for _, or := range criteria.Or {
+		if !isEmptyCriteria(or[0]) && !isEmptyCriteria(or[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// DeleteWhere elimina i messaggi che corrispondono ai criteri (vedi parseSearchCriteria) e ne restituisce gli UID
+// Criteri vuoti sono rifiutati: per svuotare la mailbox va passato esplicitamente { all: true }
+// deleteOpts è facoltativo: { mailbox, dryRun }; con dryRun restituisce gli UID senza eliminare nulla
+// Vengono rimossi solo i messaggi trovati (UID EXPUNGE), non gli altri già marcati \Deleted (vedi expunge)
+// Usage da JavaScript: const [uids, err] = client.deleteWhere({ subject: "k6 run", before: "2024-01-01" })
+func (e *EmailClient) DeleteWhere(criteriaObj map[string]interface{}, deleteOpts map[string]interface{}) ([]uint32, error) {
+	args, err := e.parseDeleteWhere(criteriaObj, deleteOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.deleteWhere(args)
+}
+
+// deleteWhere esegue DeleteWhere con gli argomenti già convertiti
+func (e *EmailClient) deleteWhere(args deleteWhereArgs) ([]uint32, error) {
+	e.lock()
+	defer e.unlock()
+
+	if e.client == nil {
+		return nil, notConnected()
+	}
+
+	// In dry run basta la sola lettura
+	if _, err := e.selectMailbox(args.mailbox, args.dryRun); err != nil {
+		return nil, err
+	}
+
+	ids, err := e.search(args.criteria)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 || args.dryRun {
+		if ids == nil {
+			ids = []uint32{}
+		}
+		return ids, nil
+	}
+
+	uidSet := new(imap.SeqSet)
+	uidSet.AddNum(ids...)
+
+	if err := e.store(uidSet, imap.AddFlags, []string{imap.DeletedFlag}); err != nil {
+		return nil, err
+	}
+
+	if err := e.expunge(uidSet); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
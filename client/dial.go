@@ -0,0 +1,75 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/emersion/go-imap/client"
+)
+
+// Modalità di connessione supportate dall'opzione "security"
+const (
+	SecurityTLS      = "tls"      // TLS implicito (porta 993)
+	SecurityStartTLS = "starttls" // connessione in chiaro aggiornata con STARTTLS (porta 143)
+	SecurityNone     = "none"     // connessione in chiaro, solo per server di test
+)
+
+// parseSecurity valida il valore dell'opzione "security"
+func parseSecurity(security string) (string, error) {
+	switch security {
+	case "":
+		return SecurityTLS, nil
+	case SecurityTLS, SecurityStartTLS, SecurityNone:
+		return security, nil
+	}
+	return "", fmt.Errorf("unsupported security %q, use one of tls, starttls, none", security)
+}
+
+// dial apre la connessione verso il server IMAP applicando le opzioni indicate
+// Il traffico della connessione viene contato in counter (per le metriche)
+// In modalità starttls fallisce se il server non annuncia la capability STARTTLS
+func dial(host string, port int, opts Options, counter *byteCounter) (*client.Client, error) {
+	addr := host + ":" + strconv.Itoa(port)
+
+	security, err := parseSecurity(opts.Security)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := countingDialer{counter: counter}
+
+	if security == SecurityNone {
+		return client.DialWithDialer(dialer, addr)
+	}
+
+	config, err := opts.TLS.Config(host)
+	if err != nil {
+		return nil, err
+	}
+
+	if security == SecurityTLS {
+		return client.DialWithDialerTLS(dialer, addr, config)
+	}
+
+	c, err := client.DialWithDialer(dialer, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := c.SupportStartTLS()
+	if err != nil {
+		c.Terminate()
+		return nil, err
+	}
+	if !ok {
+		c.Terminate()
+		return nil, fmt.Errorf("server %s does not advertise STARTTLS", addr)
+	}
+
+	if err := c.StartTLS(config); err != nil {
+		c.Terminate()
+		return nil, fmt.Errorf("STARTTLS failed: %v", err)
+	}
+
+	return c, nil
+}
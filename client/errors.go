@@ -0,0 +1,170 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// Codici di ImapError
+const (
+	ErrCodeConnection = "connection" // client non connesso, connessione chiusa o server non raggiungibile
+	ErrCodeAuth       = "auth"       // credenziali o token rifiutati
+	ErrCodeNotFound   = "notFound"   // mailbox, messaggio o parte inesistente
+	ErrCodeTimeout    = "timeout"    // timeout di rete o di WaitNewEmail
+	ErrCodeProtocol   = "protocol"   // il server ha risposto NO/BAD o con una risposta non valida
+	ErrCodeCancelled  = "cancelled"  // attesa annullata o VU interrotto da k6
+	ErrCodeInvalid    = "invalid"    // argomenti o opzioni non validi
+)
+
+// ImapError descrive un errore in modo strutturato; con errorMode "throw" diventa un Error JavaScript
+// con name "ImapError" e le proprietà code, responseCode, responseArguments e serverText
+// I metodi del client restituiscono gli errori come ImapError (vedi newError e track): il testo
+// diventa la stringa di errore di [valore, errore] solo nel wrapper JavaScript (vedi NewClientObject)
+type ImapError struct {
+	Message           string
+	Code              string
+	ResponseCode      string   // response code IMAP (es. AUTHENTICATIONFAILED), se il server lo ha indicato
+	ResponseArguments []string // argomenti del response code (es. i charset di BADCHARSET)
+	ServerText        string   // testo della risposta NO/BAD, se l'errore viene dal server
+
+	err error // errore originale del comando IMAP, se presente
+}
+
+func (err *ImapError) Error() string {
+	return err.Message
+}
+
+func (err *ImapError) Unwrap() error {
+	return err.err
+}
+
+// newError crea un ImapError con il codice indicato
+func newError(code, format string, args ...interface{}) *ImapError {
+	return &ImapError{Message: fmt.Sprintf(format, args...), Code: code}
+}
+
+// notConnected è l'errore dei metodi chiamati prima di login()
+func notConnected() *ImapError {
+	return newError(ErrCodeConnection, "Client not connected. Call login() first.")
+}
+
+// responseError è una risposta NO/BAD letta con Execute (vedi statusError): a differenza di
+// status.Err() di go-imap conserva il response code
+type responseError struct {
+	status *imap.StatusResp
+}
+
+func (err *responseError) Error() string {
+	if err.status.Code != "" {
+		return fmt.Sprintf("[%s] %s", err.status.Code, err.status.Info)
+	}
+	return err.status.Info
+}
+
+// toImapError converte un errore restituito dal client in ImapError
+// Se err contiene già un ImapError (anche avvolto da fmt.Errorf con %w) ne conserva codice e
+// risposta del server, con il testo completo di err; gli altri errori sono argomenti o opzioni non validi
+func toImapError(err error) *ImapError {
+	var imapErr *ImapError
+	if errors.As(err, &imapErr) {
+		result := *imapErr
+		result.Message = err.Error()
+		return &result
+	}
+
+	code := ErrCodeInvalid
+	if errors.Is(err, errWaitCancelled) || errors.Is(err, errContextDone) {
+		code = ErrCodeCancelled
+	}
+	return &ImapError{Message: err.Error(), Code: code, err: err}
+}
+
+// commandError classifica l'errore di un comando IMAP in base al tipo, al response code e al comando
+// Gli errori che contengono già un ImapError ne conservano la classificazione
+func commandError(command string, err error) *ImapError {
+	var imapErr *ImapError
+	if errors.As(err, &imapErr) {
+		return toImapError(err)
+	}
+
+	result := &ImapError{Message: err.Error(), err: err}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		result.Code = ErrCodeTimeout
+		return result
+	}
+
+	if isConnectionError(err) || command == "connect" {
+		result.Code = ErrCodeConnection
+		return result
+	}
+
+	// Errore del server: se letto con Execute il response code è disponibile
+	result.ServerText = err.Error()
+	var respErr *responseError
+	if errors.As(err, &respErr) {
+		result.ResponseCode = string(respErr.status.Code)
+		result.ResponseArguments = responseArguments(respErr.status.Arguments)
+		result.ServerText = respErr.status.Info
+
+		switch result.ResponseCode {
+		case "AUTHENTICATIONFAILED", "AUTHORIZATIONFAILED", "EXPIRED", "PRIVACYREQUIRED":
+			result.Code = ErrCodeAuth
+			return result
+		case "NONEXISTENT", "TRYCREATE":
+			result.Code = ErrCodeNotFound
+			return result
+		case "UNAVAILABLE":
+			result.Code = ErrCodeConnection
+			return result
+		}
+	}
+
+	switch command {
+	case "login":
+		result.Code = ErrCodeAuth
+	case "select":
+		// SELECT fallisce quasi sempre perché la mailbox non esiste o non è accessibile
+		result.Code = ErrCodeNotFound
+	default:
+		result.Code = ErrCodeProtocol
+	}
+	return result
+}
+
+// responseArguments converte in stringhe gli argomenti di un response code
+// Le liste vengono appiattite: [BADCHARSET (US-ASCII UTF-8)] diventa ["US-ASCII", "UTF-8"]
+func responseArguments(args []interface{}) []string {
+	result := make([]string, 0, len(args))
+	for _, arg := range args {
+		if list, ok := arg.([]interface{}); ok {
+			result = append(result, responseArguments(list)...)
+		} else if s, err := imap.ParseString(arg); err == nil {
+			result = append(result, s)
+		} else {
+			result = append(result, fmt.Sprint(arg))
+		}
+	}
+	return result
+}
+
+// isConnectionError riconosce gli errori di rete e di connessione chiusa
+func isConnectionError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, client.ErrAlreadyLoggedOut) || errors.Is(err, client.ErrNotLoggedIn) {
+		return true
+	}
+	// go-imap non esporta l'errore di connessione chiusa
+	return strings.HasPrefix(err.Error(), "imap: connection closed")
+}
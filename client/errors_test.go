@@ -0,0 +1,181 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/emersion/go-imap"
+)
+
+// timeoutError simula un errore di rete scaduto
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var _ net.Error = timeoutError{}
+
+func serverError(code imap.StatusRespCode, args []interface{}, info string) error {
+	return statusError(&imap.StatusResp{Type: imap.StatusRespNo, Code: code, Arguments: args, Info: info})
+}
+
+func TestCommandError(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		err     error
+		want    *ImapError
+	}{
+		{
+			name:    "network timeout",
+			command: "fetch",
+			err:     timeoutError{},
+			want:    &ImapError{Message: "i/o timeout", Code: ErrCodeTimeout},
+		},
+		{
+			name:    "connection closed",
+			command: "search",
+			err:     io.EOF,
+			want:    &ImapError{Message: "EOF", Code: ErrCodeConnection},
+		},
+		{
+			name:    "dial failure",
+			command: "connect",
+			err:     errors.New("tls: handshake failure"),
+			want:    &ImapError{Message: "tls: handshake failure", Code: ErrCodeConnection},
+		},
+		{
+			name:    "authentication failed",
+			command: "login",
+			err:     serverError("AUTHENTICATIONFAILED", nil, "Invalid credentials"),
+			want: &ImapError{
+				Message:           "[AUTHENTICATIONFAILED] Invalid credentials",
+				Code:              ErrCodeAuth,
+				ResponseCode:      "AUTHENTICATIONFAILED",
+				ResponseArguments: []string{},
+				ServerText:        "Invalid credentials",
+			},
+		},
+		{
+			name:    "login without response code",
+			command: "login",
+			err:     serverError("", nil, "Login failed"),
+			want: &ImapError{
+				Message:           "Login failed",
+				Code:              ErrCodeAuth,
+				ResponseArguments: []string{},
+				ServerText:        "Login failed",
+			},
+		},
+		{
+			name:    "missing destination mailbox",
+			command: "copy",
+			err:     serverError("TRYCREATE", nil, "Mailbox doesn't exist"),
+			want: &ImapError{
+				Message:           "[TRYCREATE] Mailbox doesn't exist",
+				Code:              ErrCodeNotFound,
+				ResponseCode:      "TRYCREATE",
+				ResponseArguments: []string{},
+				ServerText:        "Mailbox doesn't exist",
+			},
+		},
+		{
+			name:    "server unavailable",
+			command: "fetch",
+			err:     serverError("UNAVAILABLE", nil, "Try again later"),
+			want: &ImapError{
+				Message:           "[UNAVAILABLE] Try again later",
+				Code:              ErrCodeConnection,
+				ResponseCode:      "UNAVAILABLE",
+				ResponseArguments: []string{},
+				ServerText:        "Try again later",
+			},
+		},
+		{
+			name:    "response code arguments",
+			command: "search",
+			err:     serverError(imap.CodeBadCharset, []interface{}{[]interface{}{"US-ASCII", "UTF-8"}}, "Unsupported charset"),
+			want: &ImapError{
+				Message:           "[BADCHARSET] Unsupported charset",
+				Code:              ErrCodeProtocol,
+				ResponseCode:      "BADCHARSET",
+				ResponseArguments: []string{"US-ASCII", "UTF-8"},
+				ServerText:        "Unsupported charset",
+			},
+		},
+		{
+			name:    "select without response code",
+			command: "select",
+			err:     serverError("", nil, "Mailbox doesn't exist: Missing"),
+			want: &ImapError{
+				Message:           "Mailbox doesn't exist: Missing",
+				Code:              ErrCodeNotFound,
+				ResponseArguments: []string{},
+				ServerText:        "Mailbox doesn't exist: Missing",
+			},
+		},
+		{
+			name:    "other server error",
+			command: "store",
+			err:     errors.New("Permission denied"),
+			want:    &ImapError{Message: "Permission denied", Code: ErrCodeProtocol, ServerText: "Permission denied"},
+		},
+		{
+			name:    "already classified",
+			command: "expunge",
+			err:     fmt.Errorf("expunging: %w", newError(ErrCodeNotFound, "No message")),
+			want:    &ImapError{Message: "expunging: No message", Code: ErrCodeNotFound},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := commandError(tt.command, tt.err)
+			got.err = nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("commandError(%q, %v) = %+v, want %+v", tt.command, tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandErrorUnwrap(t *testing.T) {
+	err := commandError("login", fmt.Errorf("%w: %w", errTokenRejected, serverError("AUTHENTICATIONFAILED", nil, "Invalid token")))
+	if !errors.Is(err, errTokenRejected) {
+		t.Errorf("errors.Is(%v, errTokenRejected) = false, want true", err)
+	}
+	if err.Code != ErrCodeAuth || err.ResponseCode != "AUTHENTICATIONFAILED" {
+		t.Errorf("commandError() = %+v, want code auth and response code AUTHENTICATIONFAILED", err)
+	}
+}
+
+func TestToImapError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code string
+	}{
+		{"invalid argument", errors.New("invalid UID 0"), ErrCodeInvalid},
+		{"wait cancelled", errWaitCancelled, ErrCodeCancelled},
+		{"context done", fmt.Errorf("wait: %w", errContextDone), ErrCodeCancelled},
+		{"not connected", notConnected(), ErrCodeConnection},
+		{"wrapped", fmt.Errorf("error selecting Missing: %w", commandError("select", serverError("NONEXISTENT", nil, "No such mailbox"))), ErrCodeNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := toImapError(tt.err)
+			if got.Code != tt.code {
+				t.Errorf("toImapError(%v).Code = %q, want %q", tt.err, got.Code, tt.code)
+			}
+			if got.Message != tt.err.Error() {
+				t.Errorf("toImapError(%v).Message = %q, want %q", tt.err, got.Message, tt.err.Error())
+			}
+		})
+	}
+}
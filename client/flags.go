@@ -0,0 +1,187 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// I metodi di questo file modificano i flag dei messaggi indicati per UID
+// uids accetta un set IMAP come "1:100,200", un singolo UID o un array di UID
+// flagOpts è facoltativo: { mailbox, uidValidity } come in FetchByUid
+// Restituiscono i flag risultanti: [{ uid, flags }]
+
+// MarkSeen aggiunge \Seen ai messaggi, come se l'utente li avesse letti
+// Usage da JavaScript: const [result, err] = client.markSeen(message.uid)
+func (e *EmailClient) MarkSeen(uids interface{}, flagOpts map[string]interface{}) ([]map[string]interface{}, error) {
+	return e.changeFlags(uids, flagOpts, imap.AddFlags, []string{imap.SeenFlag})
+}
+
+// MarkUnseen rimuove \Seen dai messaggi
+func (e *EmailClient) MarkUnseen(uids interface{}, flagOpts map[string]interface{}) ([]map[string]interface{}, error) {
+	return e.changeFlags(uids, flagOpts, imap.RemoveFlags, []string{imap.SeenFlag})
+}
+
+// Flag aggiunge \Flagged ai messaggi (la "stella" dei client di posta)
+func (e *EmailClient) Flag(uids interface{}, flagOpts map[string]interface{}) ([]map[string]interface{}, error) {
+	return e.changeFlags(uids, flagOpts, imap.AddFlags, []string{imap.FlaggedFlag})
+}
+
+// Unflag rimuove \Flagged dai messaggi
+func (e *EmailClient) Unflag(uids interface{}, flagOpts map[string]interface{}) ([]map[string]interface{}, error) {
+	return e.changeFlags(uids, flagOpts, imap.RemoveFlags, []string{imap.FlaggedFlag})
+}
+
+// AddKeywords aggiunge le keyword indicate (stringa o array, es. "processed") ai messaggi
+// Usage da JavaScript: client.addKeywords([101, 102], ["processed", "k6"])
+func (e *EmailClient) AddKeywords(uids interface{}, keywords interface{}, flagOpts map[string]interface{}) ([]map[string]interface{}, error) {
+	list, err := parseKeywords(keywords)
+	if err != nil {
+		return nil, err
+	}
+	return e.changeFlags(uids, flagOpts, imap.AddFlags, list)
+}
+
+// RemoveKeywords rimuove le keyword indicate dai messaggi
+func (e *EmailClient) RemoveKeywords(uids interface{}, keywords interface{}, flagOpts map[string]interface{}) ([]map[string]interface{}, error) {
+	list, err := parseKeywords(keywords)
+	if err != nil {
+		return nil, err
+	}
+	return e.changeFlags(uids, flagOpts, imap.RemoveFlags, list)
+}
+
+// ReplaceKeywords sostituisce le keyword dei messaggi con quelle indicate (anche nessuna)
+// I flag di sistema (\Seen, \Flagged, ...) restano invariati
+func (e *EmailClient) ReplaceKeywords(uids interface{}, keywords interface{}, flagOpts map[string]interface{}) ([]map[string]interface{}, error) {
+	list, err := parseKeywords(keywords)
+	if err != nil {
+		return nil, err
+	}
+
+	e.lock()
+	defer e.unlock()
+
+	uidSet, err := e.selectForFlags(uids, flagOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Legge le keyword attuali per rimuovere solo quelle che non devono restare
+	current, err := e.fetch(uidSet, []imap.FetchItem{imap.FetchUid, imap.FetchFlags})
+	if err != nil {
+		return nil, err
+	}
+
+	keep := make(map[string]bool, len(list))
+	for _, keyword := range list {
+		keep[strings.ToLower(keyword)] = true
+	}
+
+	var remove []string
+	removed := make(map[string]bool)
+	for _, msg := range current {
+		for _, flag := range msg.Flags {
+			lower := strings.ToLower(flag)
+			if strings.HasPrefix(flag, "\\") || keep[lower] || removed[lower] {
+				continue
+			}
+			removed[lower] = true
+			remove = append(remove, flag)
+		}
+	}
+
+	messages := current
+	if len(remove) > 0 {
+		if messages, err = e.storeFlags(uidSet, imap.RemoveFlags, remove); err != nil {
+			return nil, err
+		}
+	}
+	if len(list) > 0 {
+		if messages, err = e.storeFlags(uidSet, imap.AddFlags, list); err != nil {
+			return nil, err
+		}
+	}
+
+	return flagsToList(messages), nil
+}
+
+// changeFlags aggiunge o rimuove i flag indicati e restituisce i flag risultanti
+func (e *EmailClient) changeFlags(uids interface{}, flagOpts map[string]interface{}, op imap.FlagsOp, flags []string) ([]map[string]interface{}, error) {
+	if len(flags) == 0 {
+		return nil, errors.New("at least one keyword is required")
+	}
+
+	e.lock()
+	defer e.unlock()
+
+	uidSet, err := e.selectForFlags(uids, flagOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := e.storeFlags(uidSet, op, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	return flagsToList(messages), nil
+}
+
+// selectForFlags valida gli UID e seleziona la mailbox in lettura e scrittura, con la connessione già acquisita
+func (e *EmailClient) selectForFlags(uids interface{}, flagOpts map[string]interface{}) (*imap.SeqSet, error) {
+	if e.client == nil {
+		return nil, notConnected()
+	}
+
+	uidSet, err := parseSeqSet(uids, "uids")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := e.selectForUid(flagOpts, false); err != nil {
+		return nil, err
+	}
+
+	return uidSet, nil
+}
+
+// parseKeywords accetta una keyword o un array di keyword
+// Le keyword sono atomi IMAP: niente spazi, parentesi, virgolette o "\" iniziale (riservato ai flag di sistema)
+func parseKeywords(value interface{}) ([]string, error) {
+	keywords, err := optionStrings(map[string]interface{}{"keywords": value}, "keywords", "keywords")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, keyword := range keywords {
+		if keyword == "" || strings.ContainsAny(keyword, " (){%*\"\\]") {
+			return nil, fmt.Errorf("invalid keyword %q", keyword)
+		}
+		for _, r := range keyword {
+			if r < 0x21 || r > 0x7e {
+				return nil, fmt.Errorf("invalid keyword %q", keyword)
+			}
+		}
+	}
+
+	return keywords, nil
+}
+
+// flagsToList converte le risposte FETCH in [{ uid, flags }]
+func flagsToList(messages []*imap.Message) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(messages))
+	for _, msg := range messages {
+		flags := msg.Flags
+		if flags == nil {
+			flags = []string{}
+		}
+		result = append(result, map[string]interface{}{
+			"uid":   msg.Uid,
+			"flags": flags,
+		})
+	}
+	return result
+}
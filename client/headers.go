@@ -0,0 +1,93 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/charset"
+	"github.com/emersion/go-message/textproto"
+)
+
+func init() {
+	// go-imap decodifica le encoded word (RFC 2047) di subject, nomi e filename solo per UTF-8 e ISO-8859-1:
+	// con il charset reader di go-message vengono decodificati anche gli altri charset
+	imap.CharsetReader = charset.Reader
+}
+
+// headerField è un campo dell'header nell'ordine in cui compare nel messaggio
+type headerField struct {
+	name  string
+	value string
+}
+
+// parseHeader legge un header RFC 5322 (es. BODY[HEADER]) gestendo le righe di continuazione
+// e restituisce i campi in ordine, con le encoded word (RFC 2047) decodificate
+// Un header malformato non è un errore: si tengono i campi letti fino a quel punto
+func parseHeader(r io.Reader) []headerField {
+	h, _ := textproto.ReadHeader(bufio.NewReader(r))
+
+	header := message.Header{Header: h}
+	fields := header.Fields()
+	result := make([]headerField, 0, fields.Len())
+	for fields.Next() {
+		// Un charset sconosciuto lascia il valore così com'è
+		value, err := fields.Text()
+		if err != nil {
+			value = fields.Value()
+		}
+		result = append(result, headerField{name: rawHeaderName(fields), value: value})
+	}
+
+	return result
+}
+
+// rawHeaderName restituisce il nome del campo con le maiuscole originali (Key() lo normalizza)
+func rawHeaderName(fields message.HeaderFields) string {
+	raw, err := fields.Raw()
+	if err == nil {
+		if i := bytes.IndexByte(raw, ':'); i > 0 {
+			return string(bytes.TrimSpace(raw[:i]))
+		}
+	}
+	return fields.Key()
+}
+
+// headersToMap aggiunge gli header al messaggio JavaScript:
+// - headers: nome in minuscolo -> valore (stringa, o array se il campo è ripetuto)
+// - headerList: [{ name, value }] nell'ordine del messaggio, ripetizioni comprese
+// - getHeader(name) / getHeaders(name): primo valore (null se manca) / tutti i valori, senza distinzione di maiuscole
+func headersToMap(result map[string]interface{}, fields []headerField) {
+	headers := make(map[string]interface{}, len(fields))
+	values := make(map[string][]string, len(fields))
+	list := make([]map[string]interface{}, 0, len(fields))
+
+	for _, f := range fields {
+		key := strings.ToLower(f.name)
+		values[key] = append(values[key], f.value)
+		list = append(list, map[string]interface{}{"name": f.name, "value": f.value})
+	}
+
+	for key, v := range values {
+		if len(v) == 1 {
+			headers[key] = v[0]
+		} else {
+			headers[key] = v
+		}
+	}
+
+	result["headers"] = headers
+	result["headerList"] = list
+	result["getHeader"] = func(name string) interface{} {
+		if v := values[strings.ToLower(name)]; len(v) > 0 {
+			return v[0]
+		}
+		return nil
+	}
+	result["getHeaders"] = func(name string) []string {
+		return append([]string{}, values[strings.ToLower(name)]...)
+	}
+}
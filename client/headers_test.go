@@ -0,0 +1,114 @@
+package client
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   []headerField
+	}{
+		{
+			name:   "simple",
+			header: "From: billing@acme.com\r\nSubject: Invoice\r\n\r\n",
+			want:   []headerField{{"From", "billing@acme.com"}, {"Subject", "Invoice"}},
+		},
+		{
+			name:   "original case is kept",
+			header: "X-CAMPAIGN-ID: k6\r\nmessage-id: <1@acme.com>\r\n\r\n",
+			want:   []headerField{{"X-CAMPAIGN-ID", "k6"}, {"message-id", "<1@acme.com>"}},
+		},
+		{
+			name:   "repeated fields in order",
+			header: "Received: from a\r\nX-Tag: one\r\nReceived: from b\r\n\r\n",
+			want:   []headerField{{"Received", "from a"}, {"X-Tag", "one"}, {"Received", "from b"}},
+		},
+		{
+			name:   "folded field",
+			header: "Subject: a very long\r\n subject line\r\nTo: test@acme.com\r\n\r\n",
+			want:   []headerField{{"Subject", "a very long subject line"}, {"To", "test@acme.com"}},
+		},
+		{
+			name:   "folded with tab and LF line endings",
+			header: "Subject: first\n\tsecond\n\n",
+			want:   []headerField{{"Subject", "first second"}},
+		},
+		{
+			name:   "RFC 2047 UTF-8",
+			header: "Subject: =?UTF-8?B?RmF0dHVyYSBuwrAgNDI=?=\r\n\r\n",
+			want:   []headerField{{"Subject", "Fattura n° 42"}},
+		},
+		{
+			name:   "RFC 2047 ISO-8859-1 quoted-printable across folding",
+			header: "Subject: =?ISO-8859-1?Q?Perch=E9?=\r\n =?ISO-8859-1?Q?_s=EC?=\r\n\r\n",
+			want:   []headerField{{"Subject", "Perché sì"}},
+		},
+		{
+			name:   "malformed header keeps the fields read so far",
+			header: "Subject: ok\r\nnot a header line\r\n\r\n",
+			want:   []headerField{{"Subject", "ok"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseHeader(strings.NewReader(tt.header))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseHeader(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeadersToMap(t *testing.T) {
+	fields := []headerField{
+		{"Received", "from a"},
+		{"Subject", "Invoice"},
+		{"received", "from b"},
+	}
+
+	result := make(map[string]interface{})
+	headersToMap(result, fields)
+
+	wantHeaders := map[string]interface{}{
+		"received": []string{"from a", "from b"},
+		"subject":  "Invoice",
+	}
+	if got := result["headers"]; !reflect.DeepEqual(got, wantHeaders) {
+		t.Errorf("headers = %v, want %v", got, wantHeaders)
+	}
+
+	wantList := []map[string]interface{}{
+		{"name": "Received", "value": "from a"},
+		{"name": "Subject", "value": "Invoice"},
+		{"name": "received", "value": "from b"},
+	}
+	if got := result["headerList"]; !reflect.DeepEqual(got, wantList) {
+		t.Errorf("headerList = %v, want %v", got, wantList)
+	}
+
+	getHeader := result["getHeader"].(func(string) interface{})
+	getHeaders := result["getHeaders"].(func(string) []string)
+
+	tests := []struct {
+		name  string
+		first interface{}
+		all   []string
+	}{
+		{"RECEIVED", "from a", []string{"from a", "from b"}},
+		{"subject", "Invoice", []string{"Invoice"}},
+		{"X-Missing", nil, []string{}},
+	}
+	for _, tt := range tests {
+		if got := getHeader(tt.name); got != tt.first {
+			t.Errorf("getHeader(%q) = %v, want %v", tt.name, got, tt.first)
+		}
+		if got := getHeaders(tt.name); !reflect.DeepEqual(got, tt.all) {
+			t.Errorf("getHeaders(%q) = %v, want %v", tt.name, got, tt.all)
+		}
+	}
+}
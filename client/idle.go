@@ -0,0 +1,238 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap/client"
+)
+
+var (
+	errWaitCancelled = errors.New("WaitNewEmail was cancelled")
+	errWaitTimeout   = errors.New("WaitNewEmail timed out")
+
+	// errConnBusy indica che IDLE non è stato avviato perché un'altra operazione aspetta la connessione
+	errConnBusy = errors.New("connection busy")
+)
+
+// pollInterval è l'intervallo di polling usato quando il server non supporta IDLE
+const pollInterval = 2 * time.Second
+
+// mailboxWatch tiene il numero di messaggi annunciato dal server (EXISTS) per ogni mailbox
+// e risveglia tutte le attese di WaitNewEmail quando cambia o quando l'IDLE condiviso termina
+// Una sola attesa alla volta è in IDLE sulla connessione, le altre aspettano le sue notifiche
+// Il valore zero è pronto all'uso
+type mailboxWatch struct {
+	mu          sync.Mutex
+	messages    map[string]uint32 // ultimo EXISTS ricevuto per mailbox
+	changed     chan struct{}     // chiuso (e sostituito) a ogni notifica
+	idle        bool              // un'attesa è in IDLE
+	idleMailbox string            // mailbox dell'IDLE in corso
+}
+
+// watchState è una fotografia di mailboxWatch per una mailbox
+type watchState struct {
+	changed     <-chan struct{}
+	messages    uint32
+	known       bool
+	idle        bool
+	idleMailbox string
+}
+
+// state restituisce lo stato attuale per mailbox; changed viene chiuso alla prossima notifica
+func (w *mailboxWatch) state(mailbox string) watchState {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.changed == nil {
+		w.changed = make(chan struct{})
+	}
+	messages, known := w.messages[mailbox]
+	return watchState{
+		changed:     w.changed,
+		messages:    messages,
+		known:       known,
+		idle:        w.idle,
+		idleMailbox: w.idleMailbox,
+	}
+}
+
+// update registra un EXISTS e notifica le attese solo se il numero di messaggi è cambiato:
+// anche ogni SELECT riceve un EXISTS, che non deve risvegliare nessuno
+func (w *mailboxWatch) update(mailbox string, messages uint32) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if previous, known := w.messages[mailbox]; known && previous == messages {
+		return
+	}
+	if w.messages == nil {
+		w.messages = make(map[string]uint32)
+	}
+	w.messages[mailbox] = messages
+	w.notify()
+}
+
+// startIdle prenota l'IDLE condiviso su mailbox; false se un'altra attesa è già in IDLE
+func (w *mailboxWatch) startIdle(mailbox string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.idle {
+		return false
+	}
+	w.idle = true
+	w.idleMailbox = mailbox
+	return true
+}
+
+// endIdle libera l'IDLE condiviso e risveglia le altre attese, così una di loro può prenderlo
+func (w *mailboxWatch) endIdle() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.idle = false
+	w.idleMailbox = ""
+	w.notify()
+}
+
+// notify va chiamato con mu acquisito
+func (w *mailboxWatch) notify() {
+	if w.changed != nil {
+		close(w.changed)
+		w.changed = nil
+	}
+}
+
+// dispatchUpdates riceve le risposte non richieste del server (EXISTS/RECENT)
+// e registra in watch il numero di messaggi della mailbox selezionata
+func (e *EmailClient) dispatchUpdates(c *client.Client, updates <-chan client.Update) {
+	for {
+		select {
+		case update := <-updates:
+			mailboxUpdate, ok := update.(*client.MailboxUpdate)
+			if !ok || mailboxUpdate.Mailbox == nil {
+				continue
+			}
+			e.watch.update(mailboxUpdate.Mailbox.Name, mailboxUpdate.Mailbox.Messages)
+		case <-c.LoggedOut():
+			return
+		}
+	}
+}
+
+// waitForChange attende che il numero di messaggi di mailbox sia diverso da seen (quello visto all'ultima ricerca)
+// usando IDLE (RFC 2177) se disponibile, altrimenti aspetta il prossimo intervallo di polling
+// Restituisce nil quando conviene ripetere la ricerca, errContextDone se il contesto del VU viene cancellato
+// Se un'altra attesa è già in IDLE sulla stessa mailbox ne usa le notifiche; su un'altra mailbox torna al polling
+func (e *EmailClient) waitForChange(ctx context.Context, mailbox string, seen uint32, useIdle bool, cancelChan <-chan struct{}, timeout <-chan time.Time) error {
+	var poll, retry <-chan time.Time
+	if !useIdle {
+		poll = time.After(pollInterval)
+	}
+
+	for {
+		state := e.watch.state(mailbox)
+		if state.known && state.messages != seen {
+			return nil
+		}
+
+		if useIdle && !state.idle && retry == nil {
+			switch err := e.idle(ctx, mailbox, seen, cancelChan, timeout); err {
+			case nil:
+				continue
+			case errConnBusy:
+				// Un'altra operazione aspetta la connessione: si riprova tra poco
+				retry = time.After(busyRetryInterval)
+				continue
+			default:
+				return err
+			}
+		}
+
+		if state.idle && state.idleMailbox != mailbox && poll == nil {
+			poll = time.After(pollInterval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return errContextDone
+		case <-cancelChan:
+			return errWaitCancelled
+		case <-timeout:
+			return errWaitTimeout
+		case <-state.changed:
+			// Nuovo EXISTS o IDLE condiviso terminato: si ricontrolla lo stato
+		case <-retry:
+			retry = nil
+		case <-poll:
+			return nil
+		}
+	}
+}
+
+// idle prende l'IDLE condiviso e resta in IDLE su mailbox finché arriva una notifica, l'attesa finisce
+// o un'altra operazione chiede la connessione
+// Restituisce nil se va ricontrollato lo stato (anche quando l'IDLE era già preso da un'altra attesa)
+func (e *EmailClient) idle(ctx context.Context, mailbox string, seen uint32, cancelChan <-chan struct{}, timeout <-chan time.Time) error {
+	if !e.watch.startIdle(mailbox) {
+		return nil
+	}
+	defer e.watch.endIdle()
+
+	e.lock()
+	defer e.unlock()
+
+	interrupt := e.idleInterrupt()
+	if interrupt == nil {
+		return errConnBusy
+	}
+	defer e.clearIdleInterrupt()
+
+	// IDLE notifica solo la mailbox selezionata, che un'altra operazione può aver cambiato
+	if status := e.client.Mailbox(); status == nil || status.Name != mailbox {
+		if _, err := e.selectMailbox(mailbox, true); err != nil {
+			return err
+		}
+	}
+
+	state := e.watch.state(mailbox)
+	if state.known && state.messages != seen {
+		return nil
+	}
+
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- e.track("idle", "", func() error {
+			return e.client.Idle(stop, nil)
+		})
+	}()
+
+	var result error
+	select {
+	case <-state.changed:
+		// Nuovo EXISTS: esci da IDLE, waitForChange decide se ripetere la ricerca
+	case <-interrupt:
+		// Un'altra operazione vuole la connessione: esci da IDLE e lasciala passare
+	case <-ctx.Done():
+		result = errContextDone
+	case <-cancelChan:
+		result = errWaitCancelled
+	case <-timeout:
+		result = errWaitTimeout
+	case err := <-done:
+		// IDLE terminato dal server o per errore di connessione
+		return err
+	}
+
+	// Invia DONE e aspetta la risposta del server prima di inviare altri comandi
+	close(stop)
+	if err := <-done; err != nil && result == nil {
+		result = err
+	}
+
+	return result
+}
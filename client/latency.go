@@ -0,0 +1,158 @@
+package client
+
+import (
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.k6.io/k6/metrics"
+)
+
+// Sorgenti dell'orario di invio usate per calcolare imap_delivery_latency
+const (
+	LatencySourceDate   = "date"   // header Date del messaggio (default)
+	LatencySourceHeader = "header" // header personalizzato, es. X-Sent-At
+)
+
+// defaultSentAtHeader è l'header letto con la sorgente "header" se non specificato
+const defaultSentAtHeader = "X-Sent-At"
+
+// LatencyOptions descrive da dove leggere l'orario di invio di un messaggio
+// Un sentAt passato a WaitNewEmail ha sempre la precedenza
+type LatencyOptions struct {
+	Source string
+	Header string
+}
+
+// parseLatencyOptions converte l'oggetto JavaScript "latency" in LatencyOptions
+func parseLatencyOptions(obj map[string]interface{}) (LatencyOptions, error) {
+	var opts LatencyOptions
+	var err error
+
+	if opts.Source, err = optionString(obj, "source", "latency.source"); err != nil {
+		return opts, err
+	}
+	if opts.Header, err = optionString(obj, "header", "latency.header"); err != nil {
+		return opts, err
+	}
+
+	switch opts.Source {
+	case "":
+		opts.Source = LatencySourceDate
+	case LatencySourceDate, LatencySourceHeader:
+	default:
+		return opts, fmt.Errorf("unsupported latency.source %q, use one of date, header", opts.Source)
+	}
+
+	if opts.Header == "" {
+		opts.Header = defaultSentAtHeader
+	}
+
+	return opts, nil
+}
+
+// parseSentAtHeader interpreta il valore di un header come X-Sent-At
+// Accetta timestamp Unix (secondi o millisecondi), RFC 3339 e date RFC 5322
+func parseSentAtHeader(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		// Oltre 1e12 il valore è sicuramente in millisecondi
+		if n > 1e12 {
+			return time.UnixMilli(n), true
+		}
+		return time.Unix(n, 0), true
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, true
+	}
+
+	if t, err := mail.ParseDate(value); err == nil {
+		return t, true
+	}
+
+	return time.Time{}, false
+}
+
+// sentTime determina l'orario di invio del messaggio secondo le opzioni di latenza
+func (e *EmailClient) sentTime(emailMap map[string]interface{}, sentAt time.Time) (time.Time, bool) {
+	if !sentAt.IsZero() {
+		return sentAt, true
+	}
+
+	if e.Options.Latency.Source == LatencySourceHeader {
+		headers, _ := emailMap["headers"].(map[string]interface{})
+		switch v := headers[strings.ToLower(e.Options.Latency.Header)].(type) {
+		case string:
+			return parseSentAtHeader(v)
+		case []string:
+			if len(v) > 0 {
+				return parseSentAtHeader(v[0])
+			}
+		}
+		return time.Time{}, false
+	}
+
+	if timestamp, ok := emailMap["dateTimestamp"].(int64); ok {
+		return time.Unix(timestamp, 0), true
+	}
+
+	return time.Time{}, false
+}
+
+// recordDeliveryLatency calcola la latenza di consegna di un messaggio trovato da WaitNewEmail
+// Aggiunge deliveryLatency (invio -> arrivo sul server) e observedLatency (invio -> osservato dal VU)
+// in millisecondi all'oggetto restituito ed emette imap_delivery_latency con tag stage
+// mailbox è quella dell'attesa: la mailbox selezionata può essere cambiata da altre operazioni
+func (e *EmailClient) recordDeliveryLatency(emailMap map[string]interface{}, mailbox string, arrival, observed, sentAt time.Time) {
+	sent, ok := e.sentTime(emailMap, sentAt)
+	if !ok {
+		return
+	}
+
+	observedLatency := observed.Sub(sent)
+	emailMap["observedLatency"] = observedLatency.Milliseconds()
+
+	stages := map[string]time.Duration{"observed": observedLatency}
+
+	// INTERNALDATE ha la precisione del secondo
+	if !arrival.IsZero() {
+		deliveryLatency := arrival.Sub(sent)
+		emailMap["deliveryLatency"] = deliveryLatency.Milliseconds()
+		stages["arrival"] = deliveryLatency
+	}
+
+	if e.Metrics == nil || e.Vu == nil {
+		return
+	}
+	state := e.Vu.State()
+	if state == nil {
+		return
+	}
+
+	now := time.Now()
+	ctm := state.Tags.GetCurrentValues()
+	tags := ctm.Tags.With("host", e.Url).With("mailbox", mailbox)
+
+	samples := make([]metrics.Sample, 0, len(stages))
+	for stage, latency := range stages {
+		samples = append(samples, metrics.Sample{
+			TimeSeries: metrics.TimeSeries{
+				Metric: e.Metrics.DeliveryLatency,
+				Tags:   tags.With("stage", stage),
+			},
+			Time:     now,
+			Metadata: ctm.Metadata,
+			Value:    metrics.D(latency),
+		})
+	}
+
+	metrics.PushIfNotDone(e.Vu.Context(), state.Samples, metrics.ConnectedSamples{
+		Samples: samples,
+		Tags:    tags,
+		Time:    now,
+	})
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// busyRetryInterval è l'attesa di WaitNewEmail quando non può entrare in IDLE perché un altro comando aspetta la connessione
+const busyRetryInterval = 200 * time.Millisecond
+
+// connLock serializza l'uso della connessione IMAP tra il thread JavaScript e le goroutine di WaitNewEmail
+// Il lock copre un'intera operazione (es. SELECT + SEARCH + FETCH), così nessuno cambia la mailbox selezionata a metà
+// Mentre WaitNewEmail è in IDLE tiene la connessione: chi la chiede chiude interrupt per farlo uscire da IDLE
+type connLock struct {
+	mu sync.Mutex
+
+	state     sync.Mutex    // protegge i campi seguenti
+	waiting   int           // operazioni in attesa della connessione
+	interrupt chan struct{} // non nil mentre un IDLE è in corso
+}
+
+// lock acquisisce la connessione per un'operazione, interrompendo l'eventuale IDLE in corso
+func (e *EmailClient) lock() {
+	e.conn.state.Lock()
+	e.conn.waiting++
+	if e.conn.interrupt != nil {
+		close(e.conn.interrupt)
+		e.conn.interrupt = nil
+	}
+	e.conn.state.Unlock()
+
+	e.conn.mu.Lock()
+
+	e.conn.state.Lock()
+	e.conn.waiting--
+	e.conn.state.Unlock()
+}
+
+// unlock rilascia la connessione
+func (e *EmailClient) unlock() {
+	e.conn.mu.Unlock()
+}
+
+// idleInterrupt va chiamato con la connessione acquisita prima di entrare in IDLE
+// Restituisce il canale che viene chiuso quando un'altra operazione chiede la connessione,
+// oppure nil se qualcuno è già in attesa: in quel caso IDLE non va avviato
+func (e *EmailClient) idleInterrupt() <-chan struct{} {
+	e.conn.state.Lock()
+	defer e.conn.state.Unlock()
+
+	if e.conn.waiting > 0 {
+		return nil
+	}
+	e.conn.interrupt = make(chan struct{})
+	return e.conn.interrupt
+}
+
+// clearIdleInterrupt va chiamato all'uscita da IDLE, prima di rilasciare la connessione
+func (e *EmailClient) clearIdleInterrupt() {
+	e.conn.state.Lock()
+	e.conn.interrupt = nil
+	e.conn.state.Unlock()
+}
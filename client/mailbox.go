@@ -0,0 +1,224 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+)
+
+// defaultMailbox è la mailbox usata se né l'operazione né le opzioni del client ne indicano una
+const defaultMailbox = "INBOX"
+
+// mailboxOption restituisce la mailbox indicata in opts["mailbox"]
+// Se assente usa l'opzione mailbox del client e infine INBOX
+// I nomi non ASCII (es. "Posta indesiderata/Già letti") vengono codificati
+// in UTF-7 modificato (RFC 3501) da go-imap al momento dell'invio del comando
+func (e *EmailClient) mailboxOption(opts map[string]interface{}) (string, error) {
+	if value, ok := opts["mailbox"]; ok && value != nil {
+		mailbox, ok := value.(string)
+		if !ok || mailbox == "" {
+			return "", fmt.Errorf("mailbox must be a non-empty string")
+		}
+		return mailbox, nil
+	}
+
+	if e.Options.Mailbox != "" {
+		return e.Options.Mailbox, nil
+	}
+
+	return defaultMailbox, nil
+}
+
+// statusItems associa i nomi accettati da Status agli item di go-imap
+var statusItems = map[string]imap.StatusItem{
+	"MESSAGES":    imap.StatusMessages,
+	"RECENT":      imap.StatusRecent,
+	"UNSEEN":      imap.StatusUnseen,
+	"UIDNEXT":     imap.StatusUidNext,
+	"UIDVALIDITY": imap.StatusUidValidity,
+}
+
+// ListMailboxes restituisce le mailbox che corrispondono al pattern (es. "*" o "Archivio/%")
+// Ogni elemento contiene name, delimiter e attributes
+// Usage da JavaScript: const [mailboxes, err] = client.listMailboxes("*")
+func (e *EmailClient) ListMailboxes(pattern string) ([]map[string]interface{}, error) {
+	e.lock()
+	defer e.unlock()
+
+	if e.client == nil {
+		return nil, notConnected()
+	}
+
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	var result []map[string]interface{}
+	err := e.track("list", "", func() error {
+		mailboxes := make(chan *imap.MailboxInfo, 10)
+		done := make(chan error, 1)
+		go func() {
+			defer close(mailboxes)
+			_, err := e.execute(&commands.List{Reference: "", Mailbox: pattern}, &responses.List{Mailboxes: mailboxes})
+			done <- err
+		}()
+		for info := range mailboxes {
+			attributes := info.Attributes
+			if attributes == nil {
+				attributes = []string{}
+			}
+			result = append(result, map[string]interface{}{
+				"name":       info.Name,
+				"delimiter":  info.Delimiter,
+				"attributes": attributes,
+			})
+		}
+		return <-done
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if result == nil {
+		result = []map[string]interface{}{}
+	}
+
+	return result, nil
+}
+
+// CreateMailbox crea una nuova mailbox
+func (e *EmailClient) CreateMailbox(name string) error {
+	e.lock()
+	defer e.unlock()
+
+	if e.client == nil {
+		return notConnected()
+	}
+
+	err := e.track("create", name, func() error {
+		_, err := e.execute(&commands.Create{Mailbox: name}, nil)
+		return err
+	})
+	return err
+}
+
+// DeleteMailbox elimina una mailbox e tutti i messaggi che contiene
+func (e *EmailClient) DeleteMailbox(name string) error {
+	e.lock()
+	defer e.unlock()
+
+	if e.client == nil {
+		return notConnected()
+	}
+
+	err := e.track("delete", name, func() error {
+		_, err := e.execute(&commands.Delete{Mailbox: name}, nil)
+		return err
+	})
+	return err
+}
+
+// RenameMailbox rinomina una mailbox
+func (e *EmailClient) RenameMailbox(existingName, newName string) error {
+	e.lock()
+	defer e.unlock()
+
+	if e.client == nil {
+		return notConnected()
+	}
+
+	err := e.track("rename", existingName, func() error {
+		_, err := e.execute(&commands.Rename{Existing: existingName, New: newName}, nil)
+		return err
+	})
+	return err
+}
+
+// Subscribe aggiunge la mailbox all'elenco delle mailbox sottoscritte
+func (e *EmailClient) Subscribe(name string) error {
+	e.lock()
+	defer e.unlock()
+
+	if e.client == nil {
+		return notConnected()
+	}
+
+	err := e.track("subscribe", name, func() error {
+		_, err := e.execute(&commands.Subscribe{Mailbox: name}, nil)
+		return err
+	})
+	return err
+}
+
+// Unsubscribe rimuove la mailbox dall'elenco delle mailbox sottoscritte
+func (e *EmailClient) Unsubscribe(name string) error {
+	e.lock()
+	defer e.unlock()
+
+	if e.client == nil {
+		return notConnected()
+	}
+
+	err := e.track("unsubscribe", name, func() error {
+		_, err := e.execute(&commands.Unsubscribe{Mailbox: name}, nil)
+		return err
+	})
+	return err
+}
+
+// Status restituisce lo stato di una mailbox senza selezionarla
+// items accetta MESSAGES, RECENT, UNSEEN, UIDNEXT e UIDVALIDITY (default tutti)
+// Usage da JavaScript: const [status, err] = client.status("INBOX", ["MESSAGES", "UNSEEN"])
+func (e *EmailClient) Status(name string, items []string) (map[string]interface{}, error) {
+	e.lock()
+	defer e.unlock()
+
+	if e.client == nil {
+		return nil, notConnected()
+	}
+
+	if len(items) == 0 {
+		items = []string{"MESSAGES", "RECENT", "UNSEEN", "UIDNEXT", "UIDVALIDITY"}
+	}
+
+	requested := make([]imap.StatusItem, 0, len(items))
+	for _, item := range items {
+		statusItem, ok := statusItems[strings.ToUpper(item)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported status item %q", item)
+		}
+		requested = append(requested, statusItem)
+	}
+
+	status := new(imap.MailboxStatus)
+	err := e.track("status", name, func() error {
+		_, err := e.execute(&commands.Status{Mailbox: name, Items: requested}, &responses.Status{Mailbox: status})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{
+		"name": status.Name,
+	}
+	for _, item := range requested {
+		switch item {
+		case imap.StatusMessages:
+			result["messages"] = status.Messages
+		case imap.StatusRecent:
+			result["recent"] = status.Recent
+		case imap.StatusUnseen:
+			result["unseen"] = status.Unseen
+		case imap.StatusUidNext:
+			result["uidNext"] = status.UidNext
+		case imap.StatusUidValidity:
+			result["uidValidity"] = status.UidValidity
+		}
+	}
+
+	return result, nil
+}
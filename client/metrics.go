@@ -0,0 +1,187 @@
+package client
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"go.k6.io/k6/metrics"
+)
+
+// Metrics contiene le metriche k6 emesse per ogni operazione IMAP
+// Le metriche sono taggate con host, mailbox e command
+type Metrics struct {
+	ConnectDuration *metrics.Metric
+	LoginDuration   *metrics.Metric
+	SearchDuration  *metrics.Metric
+	FetchDuration   *metrics.Metric
+	Commands        *metrics.Metric
+	Errors          *metrics.Metric
+	DataReceived    *metrics.Metric
+	DataSent        *metrics.Metric
+	DeliveryLatency *metrics.Metric
+}
+
+// RegisterMetrics registra le metriche del modulo nel registry di k6
+// Può essere chiamata per ogni VU: il registry restituisce le metriche già esistenti
+func RegisterMetrics(registry *metrics.Registry) (*Metrics, error) {
+	m := &Metrics{}
+
+	definitions := []struct {
+		metric    **metrics.Metric
+		name      string
+		typ       metrics.MetricType
+		valueType metrics.ValueType
+	}{
+		{&m.ConnectDuration, "imap_connect_duration", metrics.Trend, metrics.Time},
+		{&m.LoginDuration, "imap_login_duration", metrics.Trend, metrics.Time},
+		{&m.SearchDuration, "imap_search_duration", metrics.Trend, metrics.Time},
+		{&m.FetchDuration, "imap_fetch_duration", metrics.Trend, metrics.Time},
+		{&m.Commands, "imap_commands", metrics.Counter, metrics.Default},
+		{&m.Errors, "imap_errors", metrics.Counter, metrics.Default},
+		{&m.DataReceived, "imap_data_received", metrics.Counter, metrics.Data},
+		{&m.DataSent, "imap_data_sent", metrics.Counter, metrics.Data},
+		{&m.DeliveryLatency, "imap_delivery_latency", metrics.Trend, metrics.Time},
+	}
+
+	for _, def := range definitions {
+		metric, err := registry.NewMetric(def.name, def.typ, def.valueType)
+		if err != nil {
+			return nil, err
+		}
+		*def.metric = metric
+	}
+
+	return m, nil
+}
+
+// durationFor restituisce il Trend della durata associato al comando, se esiste
+func (m *Metrics) durationFor(command string) *metrics.Metric {
+	switch command {
+	case "connect":
+		return m.ConnectDuration
+	case "login":
+		return m.LoginDuration
+	case "search":
+		return m.SearchDuration
+	case "fetch":
+		return m.FetchDuration
+	}
+	return nil
+}
+
+// byteCounter conta i byte scambiati con il server dall'ultima lettura
+type byteCounter struct {
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+// countingConn è una net.Conn che aggiorna un byteCounter a ogni lettura/scrittura
+type countingConn struct {
+	net.Conn
+	counter *byteCounter
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.counter.received.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.counter.sent.Add(int64(n))
+	return n, err
+}
+
+// countingDialer apre connessioni TCP che contano il traffico in counter
+type countingDialer struct {
+	counter *byteCounter
+}
+
+func (d countingDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := new(net.Dialer).Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if d.counter == nil {
+		return conn, nil
+	}
+	return &countingConn{Conn: conn, counter: d.counter}, nil
+}
+
+// track esegue un comando IMAP ed emette le metriche relative
+// Se mailbox è vuota viene usata la mailbox attualmente selezionata
+// L'errore restituito è un ImapError classificato in base al comando (vedi commandError)
+func (e *EmailClient) track(command, mailbox string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	duration := time.Since(start)
+
+	if mailbox == "" && e.client != nil {
+		if status := e.client.Mailbox(); status != nil {
+			mailbox = status.Name
+		}
+	}
+
+	e.pushCommandMetrics(command, mailbox, duration, err)
+
+	if err != nil {
+		return commandError(command, err)
+	}
+	return nil
+}
+
+// pushCommandMetrics invia i campioni di un comando al VU
+// Nel contesto init (State nil) le metriche non vengono emesse
+func (e *EmailClient) pushCommandMetrics(command, mailbox string, duration time.Duration, err error) {
+	if e.Metrics == nil || e.Vu == nil {
+		return
+	}
+	state := e.Vu.State()
+	if state == nil {
+		return
+	}
+
+	now := time.Now()
+	ctm := state.Tags.GetCurrentValues()
+	tags := ctm.Tags.With("host", e.Url).With("command", command)
+	if mailbox != "" {
+		tags = tags.With("mailbox", mailbox)
+	}
+
+	sample := func(metric *metrics.Metric, value float64) metrics.Sample {
+		return metrics.Sample{
+			TimeSeries: metrics.TimeSeries{Metric: metric, Tags: tags},
+			Time:       now,
+			Metadata:   ctm.Metadata,
+			Value:      value,
+		}
+	}
+
+	samples := []metrics.Sample{sample(e.Metrics.Commands, 1)}
+
+	if trend := e.Metrics.durationFor(command); trend != nil {
+		samples = append(samples, sample(trend, metrics.D(duration)))
+	}
+
+	if err != nil {
+		samples = append(samples, sample(e.Metrics.Errors, 1))
+	}
+
+	// Il traffico accumulato dall'ultimo comando viene attribuito a questo comando
+	if e.counter != nil {
+		if sent := e.counter.sent.Swap(0); sent > 0 {
+			samples = append(samples, sample(e.Metrics.DataSent, float64(sent)))
+		}
+		if received := e.counter.received.Swap(0); received > 0 {
+			samples = append(samples, sample(e.Metrics.DataReceived, float64(received)))
+		}
+	}
+
+	metrics.PushIfNotDone(e.Vu.Context(), state.Samples, metrics.ConnectedSamples{
+		Samples: samples,
+		Tags:    tags,
+		Time:    now,
+	})
+}
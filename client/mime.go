@@ -0,0 +1,218 @@
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime/quotedprintable"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message/charset"
+)
+
+// Il corpo dei messaggi viene letto a partire da BODYSTRUCTURE: si scaricano con BODY.PEEK[section]
+// solo le parti text/plain e text/html, poi si decodificano Content-Transfer-Encoding e charset
+
+// mimePart è una parte foglia di BODYSTRUCTURE con il suo numero di sezione IMAP (es. "1.2")
+type mimePart struct {
+	section string
+	part    *imap.BodyStructure
+}
+
+// sectionName converte il percorso di una parte nel numero di sezione IMAP
+func sectionName(path []int) string {
+	parts := make([]string, len(path))
+	for i, n := range path {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}
+
+// textParts restituisce la prima parte text/plain e la prima text/html che non sono allegati
+// Non scende nei messaggi inoltrati (message/rfc822), che restano parti a sé
+func textParts(bs *imap.BodyStructure) (text, html *mimePart) {
+	bs.Walk(func(path []int, part *imap.BodyStructure) bool {
+		if len(part.Parts) > 0 || !strings.EqualFold(part.MIMEType, "text") {
+			return true
+		}
+		if strings.EqualFold(part.Disposition, "attachment") {
+			return true
+		}
+		switch strings.ToLower(part.MIMESubType) {
+		case "plain":
+			if text == nil {
+				text = &mimePart{section: sectionName(path), part: part}
+			}
+		case "html":
+			if html == nil {
+				html = &mimePart{section: sectionName(path), part: part}
+			}
+		}
+		return true
+	})
+	return text, html
+}
+
+// fetchTextParts scarica le parti testuali dei messaggi che hanno BODYSTRUCTURE
+// e le aggiunge a msg.Body, così messageToMap può decodificarle
+// I messaggi con le stesse sezioni vengono recuperati con un unico UID FETCH
+func (e *EmailClient) fetchTextParts(messages []*imap.Message) error {
+	groups := make(map[string]*imap.SeqSet)
+	byUid := make(map[uint32]*imap.Message, len(messages))
+
+	for _, msg := range messages {
+		if msg.BodyStructure == nil {
+			continue
+		}
+		var sections []string
+		text, html := textParts(msg.BodyStructure)
+		for _, p := range []*mimePart{text, html} {
+			if p != nil {
+				sections = append(sections, p.section)
+			}
+		}
+		if len(sections) == 0 {
+			continue
+		}
+
+		key := strings.Join(sections, " ")
+		if groups[key] == nil {
+			groups[key] = new(imap.SeqSet)
+		}
+		groups[key].AddNum(msg.Uid)
+		byUid[msg.Uid] = msg
+	}
+
+	for key, uidSet := range groups {
+		items := []imap.FetchItem{imap.FetchUid}
+		for _, section := range strings.Fields(key) {
+			items = append(items, imap.FetchItem("BODY.PEEK["+section+"]"))
+		}
+
+		fetched, err := e.fetch(uidSet, items)
+		if err != nil {
+			return err
+		}
+
+		for _, f := range fetched {
+			msg, ok := byUid[f.Uid]
+			if !ok {
+				continue
+			}
+			if msg.Body == nil {
+				msg.Body = make(map[*imap.BodySectionName]imap.Literal)
+			}
+			for name, literal := range f.Body {
+				msg.Body[name] = literal
+			}
+		}
+	}
+
+	return nil
+}
+
+// decodePartBody restituisce il contenuto decodificato di una parte già scaricata
+// ok è false se la parte non è presente nel messaggio
+// Se la decodifica fallisce (es. base64 malformato) restituisce il contenuto grezzo insieme all'errore,
+// così una parte rovinata non impedisce di leggere il resto del messaggio
+func decodePartBody(msg *imap.Message, p *mimePart) (string, bool, error) {
+	section, err := imap.ParseBodySectionName(imap.FetchItem("BODY[" + p.section + "]"))
+	if err != nil {
+		return "", false, err
+	}
+
+	r := msg.GetBody(section)
+	if r == nil {
+		return "", false, nil
+	}
+
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", false, err
+	}
+
+	b, err := decodePart(bytes.NewReader(raw), p.part.Encoding, p.part.Params["charset"])
+	if err != nil {
+		return string(raw), true, err
+	}
+	return string(b), true, nil
+}
+
+// decodePart decodifica il Content-Transfer-Encoding e, se indicato, converte il charset in UTF-8
+// Un charset sconosciuto non è un errore: il contenuto viene restituito così com'è
+func decodePart(r io.Reader, encoding, charsetName string) ([]byte, error) {
+	switch strings.ToLower(encoding) {
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	}
+
+	switch strings.ToLower(charsetName) {
+	case "", "utf-8", "us-ascii":
+	default:
+		if cr, err := charset.Reader(charsetName, r); err == nil {
+			r = cr
+		}
+	}
+
+	return ioutil.ReadAll(r)
+}
+
+// structureToMap converte BODYSTRUCTURE in un oggetto JavaScript
+// Le parti multipart hanno l'elenco dei figli in parts, le foglie il numero di sezione da usare con BODY[section]
+// decodeErrors associa alle sezioni non decodificate l'errore, riportato nella parte come decodeError
+func structureToMap(bs *imap.BodyStructure, path []int, decodeErrors map[string]string) map[string]interface{} {
+	if len(bs.Parts) > 0 {
+		result := map[string]interface{}{
+			"contentType": strings.ToLower(bs.MIMEType + "/" + bs.MIMESubType),
+		}
+		if path != nil {
+			result["section"] = sectionName(path)
+		}
+		parts := make([]map[string]interface{}, 0, len(bs.Parts))
+		for i, part := range bs.Parts {
+			childPath := append(append([]int(nil), path...), i+1)
+			parts = append(parts, structureToMap(part, childPath, decodeErrors))
+		}
+		result["parts"] = parts
+		return result
+	}
+
+	// Un messaggio non multipart ha solo la parte 1
+	if path == nil {
+		path = []int{1}
+	}
+	section := sectionName(path)
+	result := partToMap(bs, section)
+	if decodeErr, ok := decodeErrors[section]; ok {
+		result["decodeError"] = decodeErr
+	}
+	return result
+}
+
+// partToMap descrive una parte foglia: tipo, sezione, dimensione codificata, encoding, charset,
+// disposition, nome del file e Content-ID (stringa vuota se mancano)
+func partToMap(bs *imap.BodyStructure, section string) map[string]interface{} {
+	filename, _ := bs.Filename()
+
+	result := map[string]interface{}{
+		"contentType": strings.ToLower(bs.MIMEType + "/" + bs.MIMESubType),
+		"section":     section,
+		"size":        bs.Size,
+		"disposition": strings.ToLower(bs.Disposition),
+		"filename":    filename,
+		"contentId":   strings.Trim(bs.Id, "<>"),
+	}
+	if bs.Encoding != "" {
+		result["encoding"] = strings.ToLower(bs.Encoding)
+	}
+	if cs := bs.Params["charset"]; cs != "" {
+		result["charset"] = cs
+	}
+
+	return result
+}
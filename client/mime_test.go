@@ -0,0 +1,186 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/emersion/go-imap"
+)
+
+// leaf, attachment e multipart costruiscono le BODYSTRUCTURE dei test
+func leaf(mimeType, subType string) *imap.BodyStructure {
+	return &imap.BodyStructure{MIMEType: mimeType, MIMESubType: subType, Params: map[string]string{}}
+}
+
+func attachment(mimeType, subType, filename string) *imap.BodyStructure {
+	part := leaf(mimeType, subType)
+	part.Disposition = "attachment"
+	part.DispositionParams = map[string]string{"filename": filename}
+	return part
+}
+
+func multipart(subType string, parts ...*imap.BodyStructure) *imap.BodyStructure {
+	return &imap.BodyStructure{MIMEType: "multipart", MIMESubType: subType, Parts: parts}
+}
+
+// sectionOf restituisce la sezione della parte, vuota se manca
+func sectionOf(p *mimePart) string {
+	if p == nil {
+		return ""
+	}
+	return p.section
+}
+
+func TestTextParts(t *testing.T) {
+	tests := []struct {
+		name      string
+		structure *imap.BodyStructure
+		text      string
+		html      string
+	}{
+		{
+			name:      "single part",
+			structure: leaf("text", "plain"),
+			text:      "1",
+		},
+		{
+			name:      "single html part",
+			structure: leaf("TEXT", "HTML"),
+			html:      "1",
+		},
+		{
+			name:      "alternative",
+			structure: multipart("alternative", leaf("text", "plain"), leaf("text", "html")),
+			text:      "1",
+			html:      "2",
+		},
+		{
+			name: "alternative inside mixed",
+			structure: multipart("mixed",
+				multipart("alternative", leaf("text", "plain"), leaf("text", "html")),
+				attachment("application", "pdf", "invoice.pdf"),
+			),
+			text: "1.1",
+			html: "1.2",
+		},
+		{
+			name: "text attachment is not the body",
+			structure: multipart("mixed",
+				attachment("text", "plain", "notes.txt"),
+				leaf("text", "html"),
+			),
+			html: "2",
+		},
+		{
+			name: "first text part wins",
+			structure: multipart("mixed",
+				leaf("image", "png"),
+				leaf("text", "plain"),
+				leaf("text", "plain"),
+			),
+			text: "2",
+		},
+		{
+			name: "forwarded message is not descended",
+			structure: multipart("mixed",
+				leaf("text", "plain"),
+				&imap.BodyStructure{MIMEType: "message", MIMESubType: "rfc822", BodyStructure: leaf("text", "html")},
+			),
+			text: "1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, html := textParts(tt.structure)
+			if got := sectionOf(text); got != tt.text {
+				t.Errorf("textParts() text section = %q, want %q", got, tt.text)
+			}
+			if got := sectionOf(html); got != tt.html {
+				t.Errorf("textParts() html section = %q, want %q", got, tt.html)
+			}
+		})
+	}
+}
+
+func TestStructureToMapSinglePart(t *testing.T) {
+	got := structureToMap(leaf("text", "plain"), nil, nil)
+	if got["section"] != "1" {
+		t.Errorf("structureToMap() section = %v, want 1", got["section"])
+	}
+}
+
+func TestDecodePart(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		encoding string
+		charset  string
+		want     string
+		wantErr  bool
+	}{
+		{name: "plain", content: "Ciao", want: "Ciao"},
+		{name: "base64", content: "Q2lhbyBtb25kbw==", encoding: "BASE64", want: "Ciao mondo"},
+		{name: "quoted-printable", content: "Perch=C3=A9 s=C3=AC", encoding: "quoted-printable", charset: "utf-8", want: "Perché sì"},
+		{name: "latin1", content: "Perch\xe9", charset: "ISO-8859-1", want: "Perché"},
+		{name: "unknown charset", content: "abc", charset: "x-unknown", want: "abc"},
+		{name: "invalid base64", content: "not base64!", encoding: "base64", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodePart(bytes.NewBufferString(tt.content), tt.encoding, tt.charset)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("decodePart() = %q, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodePart() error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("decodePart() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodePartBody(t *testing.T) {
+	section, err := imap.ParseBodySectionName("BODY[1]")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		content  string
+		encoding string
+		want     string
+		wantOk   bool
+		wantErr  bool
+	}{
+		{name: "decoded", content: "Q2lhbw==", encoding: "base64", want: "Ciao", wantOk: true},
+		{name: "raw content when decoding fails", content: "%%%", encoding: "base64", want: "%%%", wantOk: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			part := leaf("text", "plain")
+			part.Encoding = tt.encoding
+			msg := &imap.Message{Body: map[*imap.BodySectionName]imap.Literal{section: bytes.NewBufferString(tt.content)}}
+
+			got, ok, err := decodePartBody(msg, &mimePart{section: "1", part: part})
+			if got != tt.want || ok != tt.wantOk || (err != nil) != tt.wantErr {
+				t.Errorf("decodePartBody() = %q, %v, %v, want %q, %v, error %v", got, ok, err, tt.want, tt.wantOk, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("missing part", func(t *testing.T) {
+		msg := &imap.Message{Body: map[*imap.BodySectionName]imap.Literal{}}
+		if _, ok, err := decodePartBody(msg, &mimePart{section: "2", part: leaf("text", "plain")}); ok || err != nil {
+			t.Errorf("decodePartBody() = %v, %v, want false, nil", ok, err)
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/grafana/sobek"
+)
+
+var (
+	promiseType = reflect.TypeOf((*sobek.Promise)(nil))
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// NewClientObject restituisce l'oggetto JavaScript del client
+// I metodi Go restituiscono gli errori come error (di solito ImapError): qui diventano il risultato previsto da errorMode
+// Con "return" chi restituisce (valore, error) restituisce [valore, errore] e chi restituisce solo error la stringa
+// di errore, vuota se va tutto bene
+// Con "throw" restituiscono il valore (o undefined) e lanciano un ImapError; le promise (WaitNewEmail, StartWait
+// e i metodi ...Async) vengono rifiutate con un ImapError
+// Usage da JavaScript:
+// const client = new Imap.Client(email, password, url, port, { errorMode: "throw" })
+// try { const message = client.read({ subject: "Invoice" }) } catch (e) { console.log(e.code, e.serverText) }
+func NewClientObject(e *EmailClient) *sobek.Object {
+	rt := e.Vu.Runtime()
+	src := rt.ToValue(e).ToObject(rt)
+	dst := rt.NewObject()
+
+	// I campi esportati restano leggibili attraverso il prototipo, come sull'oggetto Go
+	dst.SetPrototype(src)
+
+	// I metodi Go sono esposti con la prima lettera minuscola: fetchByUid -> FetchByUid
+	clientType := reflect.TypeOf(e)
+	for _, name := range src.Keys() {
+		fn, ok := sobek.AssertFunction(src.Get(name))
+		if !ok {
+			continue
+		}
+		method, ok := clientType.MethodByName(strings.ToUpper(name[:1]) + name[1:])
+		if !ok {
+			continue
+		}
+		dst.Set(name, e.wrapMethod(src, fn, method.Type))
+	}
+
+	return dst
+}
+
+// wrapMethod avvolge un metodo del client convertendone l'errore secondo errorMode (vedi NewClientObject)
+// methodType include il receiver, ma i valori restituiti non ne dipendono
+func (e *EmailClient) wrapMethod(this sobek.Value, fn sobek.Callable, methodType reflect.Type) func(sobek.FunctionCall) sobek.Value {
+	rt := e.Vu.Runtime()
+	errorMode := e.Options.ErrorMode
+	numOut := methodType.NumOut()
+	returnsError := numOut > 0 && methodType.Out(numOut-1) == errorType
+
+	return func(call sobek.FunctionCall) sobek.Value {
+		result, err := fn(this, call.Arguments...)
+
+		if returnsError {
+			// sobek lancia l'errore restituito dal metodo come GoError: lo si ritrova con Unwrap
+			var methodErr error
+			if err != nil {
+				var exception *sobek.Exception
+				if !errors.As(err, &exception) || exception.Unwrap() == nil {
+					// Eccezione JavaScript (es. argomenti non convertibili): viene rilanciata così com'è
+					panic(err)
+				}
+				methodErr = exception.Unwrap()
+				if numOut == 2 {
+					result = rt.ToValue(reflect.Zero(methodType.Out(0)).Interface())
+				}
+			}
+			return methodResult(rt, errorMode, result, numOut == 2, methodErr)
+		}
+
+		if err != nil {
+			panic(err)
+		}
+		if errorMode != ErrorModeThrow {
+			return result
+		}
+
+		switch {
+		case numOut == 1 && methodType.Out(0) == promiseType:
+			return throwingPromise(rt, result)
+		case numOut == 1 && methodType.Out(0).Kind() == reflect.Map:
+			// L'handle di StartWait contiene la promise dell'attesa
+			handle := result.ToObject(rt)
+			if promise := handle.Get("promise"); promise != nil {
+				if _, ok := promise.Export().(*sobek.Promise); ok {
+					handle.Set("promise", throwingPromise(rt, promise))
+				}
+			}
+			return handle
+		}
+		return result
+	}
+}
+
+// Result converte il risultato di una funzione del modulo nel valore JavaScript previsto da errorMode:
+// [valore, errore] con "return", il valore con "throw" lanciando l'errore come ImapError
+func Result(rt *sobek.Runtime, errorMode string, value interface{}, err error) sobek.Value {
+	return methodResult(rt, errorMode, rt.ToValue(value), true, err)
+}
+
+// methodResult converte valore ed errore di un metodo secondo errorMode
+// withValue è false per i metodi che restituiscono solo l'errore: con "return" restituiscono la sola stringa
+func methodResult(rt *sobek.Runtime, errorMode string, value sobek.Value, withValue bool, err error) sobek.Value {
+	if errorMode == ErrorModeThrow {
+		if err != nil {
+			panic(jsError(rt, toImapError(err)))
+		}
+		if !withValue {
+			return sobek.Undefined()
+		}
+		return value
+	}
+
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+	if !withValue {
+		return rt.ToValue(message)
+	}
+	return rt.NewArray(value, message)
+}
+
+// throwingPromise restituisce una promise che si risolve come promise, ma viene rifiutata con un ImapError
+func throwingPromise(rt *sobek.Runtime, promise sobek.Value) sobek.Value {
+	obj := promise.ToObject(rt)
+	then, ok := sobek.AssertFunction(obj.Get("then"))
+	if !ok {
+		return promise
+	}
+
+	onRejected := rt.ToValue(func(call sobek.FunctionCall) sobek.Value {
+		reason := call.Argument(0)
+		err, ok := reason.Export().(error)
+		if !ok {
+			err = errors.New(reason.String())
+		}
+		panic(jsError(rt, toImapError(err)))
+	})
+
+	result, err := then(obj, sobek.Undefined(), onRejected)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// jsError crea l'Error JavaScript { name: "ImapError", message, code, responseCode, responseArguments, serverText }
+func jsError(rt *sobek.Runtime, imapErr *ImapError) *sobek.Object {
+	obj, err := rt.New(rt.Get("Error"), rt.ToValue(imapErr.Message))
+	if err != nil {
+		return rt.NewGoError(imapErr)
+	}
+	obj.Set("name", "ImapError")
+	obj.Set("code", imapErr.Code)
+	obj.Set("responseCode", imapErr.ResponseCode)
+	responseArgs := imapErr.ResponseArguments
+	if responseArgs == nil {
+		responseArgs = []string{}
+	}
+	obj.Set("responseArguments", responseArgs)
+	obj.Set("serverText", imapErr.ServerText)
+	return obj
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/grafana/sobek"
+	"go.k6.io/k6/js/common"
+	"go.k6.io/k6/js/modules"
+)
+
+// testVU fornisce solo il runtime JavaScript, con i nomi dei metodi come in k6 (copy -> Copy)
+type testVU struct {
+	modules.VU
+	rt *sobek.Runtime
+}
+
+func (vu *testVU) Runtime() *sobek.Runtime { return vu.rt }
+
+func newTestVU() *testVU {
+	rt := sobek.New()
+	rt.SetFieldNameMapper(common.FieldNameMapper{})
+	return &testVU{rt: rt}
+}
+
+func TestClientObject(t *testing.T) {
+	tests := []struct {
+		name      string
+		errorMode string
+		script    string
+		want      string
+	}{
+		{
+			name:      "return mode, error only",
+			errorMode: ErrorModeReturn,
+			script:    `client.copy(1, "Archive")`,
+			want:      "Client not connected. Call login() first.",
+		},
+		{
+			name:      "return mode, value and error",
+			errorMode: ErrorModeReturn,
+			script:    `const [uids, err] = client.deleteWhere({}); JSON.stringify([uids, err])`,
+			want:      `[[],"` + errDeleteAll.Error() + `"]`,
+		},
+		{
+			name:      "return mode, fields",
+			errorMode: ErrorModeReturn,
+			script:    `client.email`,
+			want:      "test@acme.com",
+		},
+		{
+			name:      "throw mode",
+			errorMode: ErrorModeThrow,
+			script:    `try { client.read({}); "no error" } catch (e) { [e.name, e.code, e.message].join("|") }`,
+			want:      "ImapError|connection|Client not connected. Call login() first.",
+		},
+		{
+			name:      "throw mode, invalid argument",
+			errorMode: ErrorModeThrow,
+			script:    `try { client.deleteWhere({}); "no error" } catch (e) { e.code }`,
+			want:      ErrCodeInvalid,
+		},
+		{
+			name:      "throw mode, success",
+			errorMode: ErrorModeThrow,
+			script:    `String(client.cancelAllWaits())`,
+			want:      "0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vu := newTestVU()
+			rt := vu.Runtime()
+
+			e := &EmailClient{Vu: vu, Email: "test@acme.com", Options: Options{ErrorMode: tt.errorMode}}
+			if err := rt.Set("client", NewClientObject(e)); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := rt.RunString(tt.script)
+			if err != nil {
+				t.Fatalf("RunString(%q) error: %v", tt.script, err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("RunString(%q) = %q, want %q", tt.script, got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestResult(t *testing.T) {
+	tests := []struct {
+		name      string
+		errorMode string
+		err       error
+		want      string
+	}{
+		{"return mode", ErrorModeReturn, nil, `["body",""]`},
+		{"return mode, error", ErrorModeReturn, newError(ErrCodeNotFound, "No messages found"), `["body","No messages found"]`},
+		{"throw mode", ErrorModeThrow, nil, `"body"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newTestVU().Runtime()
+			got, err := rt.RunString(`JSON.stringify`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stringify, _ := sobek.AssertFunction(got)
+			value, err := stringify(sobek.Undefined(), Result(rt, tt.errorMode, "body", tt.err))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value.String() != tt.want {
+				t.Errorf("Result() = %s, want %s", value, tt.want)
+			}
+		})
+	}
+}
+
+func TestResultThrow(t *testing.T) {
+	rt := newTestVU().Runtime()
+	if err := rt.Set("read", func() sobek.Value {
+		return Result(rt, ErrorModeThrow, "", newError(ErrCodeNotFound, "No messages found"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := rt.RunString(`try { read(); "no error" } catch (e) { [e.name, e.code, e.message].join("|") }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ImapError|notFound|No messages found"; got.String() != want {
+		t.Errorf("read() threw %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"fmt"
+	"time"
+)
+
+// Options raccoglie le opzioni passate come ultimo argomento al costruttore Client
+type Options struct {
+	TLS      TLSOptions
+	Security string
+	Auth     AuthOptions
+	Latency  LatencyOptions
+	Mailbox  string // Mailbox usata quando un'operazione non ne specifica una
+
+	ErrorMode string // ErrorModeReturn (default) o ErrorModeThrow, vedi NewClientObject
+}
+
+// Modalità di errore dell'opzione errorMode
+const (
+	ErrorModeReturn = "return" // i metodi restituiscono [valore, errore] con errore stringa vuota se va tutto bene
+	ErrorModeThrow  = "throw"  // i metodi restituiscono il valore e lanciano ImapError
+)
+
+// ErrorModeOf restituisce l'opzione errorMode di obj senza validare le altre opzioni
+// Serve alle funzioni del modulo per riportare nel modo richiesto anche gli errori delle opzioni
+func ErrorModeOf(obj map[string]interface{}) string {
+	if mode, _ := obj["errorMode"].(string); mode == ErrorModeThrow {
+		return ErrorModeThrow
+	}
+	return ErrorModeReturn
+}
+
+// ParseOptions converte l'oggetto JavaScript delle opzioni in Options
+// Le chiavi non presenti mantengono il valore di default
+func ParseOptions(obj map[string]interface{}) (Options, error) {
+	var opts Options
+	var err error
+
+	if opts.Mailbox, err = optionString(obj, "mailbox", "mailbox"); err != nil {
+		return opts, err
+	}
+
+	if opts.ErrorMode, err = optionString(obj, "errorMode", "errorMode"); err != nil {
+		return opts, err
+	}
+	switch opts.ErrorMode {
+	case "":
+		opts.ErrorMode = ErrorModeReturn
+	case ErrorModeReturn, ErrorModeThrow:
+	default:
+		return opts, fmt.Errorf("unsupported errorMode %q, use one of return, throw", opts.ErrorMode)
+	}
+
+	security, err := optionString(obj, "security", "security")
+	if err != nil {
+		return opts, err
+	}
+	if opts.Security, err = parseSecurity(security); err != nil {
+		return opts, err
+	}
+
+	if value, ok := obj["tls"]; ok && value != nil {
+		tlsObj, ok := value.(map[string]interface{})
+		if !ok {
+			return opts, fmt.Errorf("option tls must be an object")
+		}
+		tlsOpts, err := parseTLSOptions(tlsObj)
+		if err != nil {
+			return opts, err
+		}
+		opts.TLS = tlsOpts
+	}
+
+	authObj := map[string]interface{}{}
+	if value, ok := obj["auth"]; ok && value != nil {
+		if authObj, ok = value.(map[string]interface{}); !ok {
+			return opts, fmt.Errorf("option auth must be an object")
+		}
+	}
+	if opts.Auth, err = parseAuthOptions(authObj); err != nil {
+		return opts, err
+	}
+
+	latencyObj := map[string]interface{}{}
+	if value, ok := obj["latency"]; ok && value != nil {
+		if latencyObj, ok = value.(map[string]interface{}); !ok {
+			return opts, fmt.Errorf("option latency must be an object")
+		}
+	}
+	if opts.Latency, err = parseLatencyOptions(latencyObj); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+// optionString legge una stringa opzionale dall'oggetto delle opzioni
+func optionString(obj map[string]interface{}, key, name string) (string, error) {
+	value, ok := obj[key]
+	if !ok || value == nil {
+		return "", nil
+	}
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("option %s must be a string", name)
+	}
+	return str, nil
+}
+
+// optionBool legge un booleano opzionale dall'oggetto delle opzioni
+func optionBool(obj map[string]interface{}, key, name string) (bool, error) {
+	value, ok := obj[key]
+	if !ok || value == nil {
+		return false, nil
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("option %s must be a boolean", name)
+	}
+	return b, nil
+}
+
+// optionStrings legge un array di stringhe opzionale dall'oggetto delle opzioni
+// Accetta anche una singola stringa
+func optionStrings(obj map[string]interface{}, key, name string) ([]string, error) {
+	value, ok := obj[key]
+	if !ok || value == nil {
+		return nil, nil
+	}
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("option %s must be an array of strings", name)
+			}
+			values = append(values, str)
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("option %s must be an array of strings", name)
+}
+
+// parseDateOption legge un orario passato da JavaScript: una Date o un timestamp in millisecondi
+// come Date.now() (zero se assente). È il formato di tutti gli orari accettati dal modulo
+// (sentAt, internalDate, date di buildMessage e le date dei criteri di ricerca)
+func parseDateOption(value interface{}, name string) (time.Time, error) {
+	switch v := value.(type) {
+	case nil:
+		return time.Time{}, nil
+	case time.Time:
+		return v, nil
+	case int64:
+		return time.UnixMilli(v), nil
+	case float64:
+		return time.UnixMilli(int64(v)), nil
+	}
+	return time.Time{}, fmt.Errorf("%s must be a Date or a timestamp in milliseconds", name)
+}
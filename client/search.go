@@ -0,0 +1,250 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// Ordinamenti supportati da Search e List
+const (
+	SortArrival = "arrival" // ordine di arrivo sul server (default)
+	SortDate    = "date"    // header Date del messaggio
+)
+
+// defaultSearchLimit è il numero massimo di messaggi completi restituiti da Search se limit non è indicato:
+// senza limite una mailbox grande verrebbe scaricata per intero in memoria a ogni chiamata
+const defaultSearchLimit = 50
+
+// fullItems sono gli item recuperati da Search: il messaggio completo come in Read
+// Le parti testuali vengono scaricate a parte da fetchTextParts in base a BODYSTRUCTURE
+var fullItems = []imap.FetchItem{
+	imap.FetchItem("UID"),
+	imap.FetchItem("ENVELOPE"),
+	imap.FetchItem("INTERNALDATE"),
+	imap.FetchItem("FLAGS"),
+	imap.FetchItem("RFC822.SIZE"),
+	imap.FetchItem("BODYSTRUCTURE"),
+	imap.FetchItem("BODY[HEADER]"),
+}
+
+// summaryItems sono gli item recuperati da List: solo envelope, date, flag e dimensione
+var summaryItems = []imap.FetchItem{
+	imap.FetchItem("UID"),
+	imap.FetchItem("ENVELOPE"),
+	imap.FetchItem("INTERNALDATE"),
+	imap.FetchItem("FLAGS"),
+	imap.FetchItem("RFC822.SIZE"),
+}
+
+// searchOptions descrive mailbox, paginazione e ordinamento di Search e List
+type searchOptions struct {
+	mailbox string
+	limit   int
+	offset  int
+	sort    string
+	desc    bool
+}
+
+// parseSearchOptions converte l'oggetto JavaScript { mailbox, limit, offset, sort, order }
+// defaultLimit è il limit usato se l'opzione manca; limit: 0 esplicito restituisce tutti i messaggi
+func (e *EmailClient) parseSearchOptions(obj map[string]interface{}, defaultLimit int) (searchOptions, error) {
+	opts := searchOptions{sort: SortArrival, desc: true, limit: defaultLimit}
+	var err error
+
+	if opts.mailbox, err = e.mailboxOption(obj); err != nil {
+		return opts, err
+	}
+	if obj["limit"] != nil {
+		if opts.limit, err = optionCount(obj, "limit"); err != nil {
+			return opts, err
+		}
+	}
+	if opts.offset, err = optionCount(obj, "offset"); err != nil {
+		return opts, err
+	}
+
+	sortBy, err := optionString(obj, "sort", "sort")
+	if err != nil {
+		return opts, err
+	}
+	switch sortBy {
+	case "":
+	case SortArrival, SortDate:
+		opts.sort = sortBy
+	default:
+		return opts, fmt.Errorf("unsupported sort %q, use one of arrival, date", sortBy)
+	}
+
+	order, err := optionString(obj, "order", "order")
+	if err != nil {
+		return opts, err
+	}
+	switch order {
+	case "", "desc":
+	case "asc":
+		opts.desc = false
+	default:
+		return opts, fmt.Errorf("unsupported order %q, use one of asc, desc", order)
+	}
+
+	return opts, nil
+}
+
+// optionCount legge un intero non negativo opzionale (0 se assente)
+func optionCount(obj map[string]interface{}, key string) (int, error) {
+	switch v := obj[key].(type) {
+	case nil:
+		return 0, nil
+	case int64:
+		if v >= 0 {
+			return int(v), nil
+		}
+	case float64:
+		if v >= 0 {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("option %s must be a non-negative number", key)
+}
+
+// Search restituisce tutti i messaggi che corrispondono ai criteri, completi di body e header
+// searchOpts è facoltativo: { mailbox, limit, offset, sort: "arrival" | "date", order: "desc" | "asc" }
+// Di default i messaggi sono ordinati dal più recente e ne vengono restituiti al massimo defaultSearchLimit
+// Usage da JavaScript: const [messages, err] = client.search({ from: "noreply@acme.com" }, { limit: 10 })
+func (e *EmailClient) Search(criteriaObj map[string]interface{}, searchOpts map[string]interface{}) ([]map[string]interface{}, error) {
+	return e.searchMessages(criteriaObj, searchOpts, fullItems, defaultSearchLimit)
+}
+
+// List è come Search ma restituisce solo un riepilogo dei messaggi (envelope, date, flag, dimensione)
+// senza scaricare body e header; senza limit restituisce tutti i messaggi trovati
+func (e *EmailClient) List(criteriaObj map[string]interface{}, searchOpts map[string]interface{}) ([]map[string]interface{}, error) {
+	return e.searchMessages(criteriaObj, searchOpts, summaryItems, 0)
+}
+
+// searchMessages cerca i messaggi, li ordina, applica la paginazione e recupera gli item indicati
+// defaultLimit è il limit usato se searchOpts non lo indica (0 = nessun limite)
+func (e *EmailClient) searchMessages(criteriaObj, searchOpts map[string]interface{}, items []imap.FetchItem, defaultLimit int) ([]map[string]interface{}, error) {
+	e.lock()
+	defer e.unlock()
+
+	if e.client == nil {
+		return nil, notConnected()
+	}
+
+	opts, err := e.parseSearchOptions(searchOpts, defaultLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	criteria, err := parseSearchCriteria(criteriaObj)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := e.selectMailbox(opts.mailbox, true); err != nil {
+		return nil, err
+	}
+
+	ids, err := e.search(criteria)
+	if err != nil {
+		return nil, err
+	}
+
+	// I risultati di UID SEARCH sono in ordine di arrivo (UID crescente)
+	if opts.sort == SortDate {
+		if ids, err = e.sortByDate(ids); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.desc {
+		for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+			ids[i], ids[j] = ids[j], ids[i]
+		}
+	}
+
+	ids = paginate(ids, opts.offset, opts.limit)
+
+	result := make([]map[string]interface{}, 0, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(ids...)
+
+	messages, err := e.fetch(seqSet, items)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := e.fetchTextParts(messages); err != nil {
+		return nil, err
+	}
+
+	// FETCH restituisce i messaggi in ordine di sequenza: riordinali come richiesto
+	byUid := make(map[uint32]*imap.Message, len(messages))
+	for _, msg := range messages {
+		byUid[msg.Uid] = msg
+	}
+
+	uidValidity := e.uidValidity()
+	for _, id := range ids {
+		msg, ok := byUid[id]
+		if !ok {
+			// Messaggio eliminato tra SEARCH e FETCH
+			continue
+		}
+		result = append(result, messageToMap(msg, uidValidity))
+	}
+
+	return result, nil
+}
+
+// sortByDate ordina i messaggi per header Date (crescente) usando l'envelope
+// Se il Date manca viene usata la data di arrivo
+func (e *EmailClient) sortByDate(ids []uint32) ([]uint32, error) {
+	if len(ids) == 0 {
+		return ids, nil
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(ids...)
+
+	messages, err := e.fetch(seqSet, []imap.FetchItem{imap.FetchEnvelope, imap.FetchInternalDate})
+	if err != nil {
+		return nil, err
+	}
+
+	dates := make(map[uint32]time.Time, len(messages))
+	for _, msg := range messages {
+		date := msg.InternalDate
+		if msg.Envelope != nil && !msg.Envelope.Date.IsZero() {
+			date = msg.Envelope.Date
+		}
+		dates[msg.Uid] = date
+	}
+
+	sorted := make([]uint32, len(ids))
+	copy(sorted, ids)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return dates[sorted[i]].Before(dates[sorted[j]])
+	})
+
+	return sorted, nil
+}
+
+// paginate applica offset e limit (0 = nessun limite) agli ID
+func paginate(ids []uint32, offset, limit int) []uint32 {
+	if offset >= len(ids) {
+		return nil
+	}
+	ids = ids[offset:]
+	if limit > 0 && limit < len(ids) {
+		ids = ids[:limit]
+	}
+	return ids
+}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+// TLSOptions descrive la configurazione TLS usata per connettersi al server IMAP
+// I certificati e le chiavi sono in formato PEM
+type TLSOptions struct {
+	CA                 string
+	Cert               string
+	Key                string
+	ServerName         string
+	MinVersion         string
+	MaxVersion         string
+	CipherSuites       []string
+	InsecureSkipVerify bool
+}
+
+// parseTLSOptions converte l'oggetto JavaScript "tls" in TLSOptions
+func parseTLSOptions(obj map[string]interface{}) (TLSOptions, error) {
+	var opts TLSOptions
+	var err error
+
+	if opts.CA, err = optionString(obj, "ca", "tls.ca"); err != nil {
+		return opts, err
+	}
+	if opts.Cert, err = optionString(obj, "cert", "tls.cert"); err != nil {
+		return opts, err
+	}
+	if opts.Key, err = optionString(obj, "key", "tls.key"); err != nil {
+		return opts, err
+	}
+	if opts.ServerName, err = optionString(obj, "serverName", "tls.serverName"); err != nil {
+		return opts, err
+	}
+	if opts.MinVersion, err = optionString(obj, "minVersion", "tls.minVersion"); err != nil {
+		return opts, err
+	}
+	if opts.MaxVersion, err = optionString(obj, "maxVersion", "tls.maxVersion"); err != nil {
+		return opts, err
+	}
+	if opts.CipherSuites, err = optionStrings(obj, "cipherSuites", "tls.cipherSuites"); err != nil {
+		return opts, err
+	}
+	if opts.InsecureSkipVerify, err = optionBool(obj, "insecureSkipVerify", "tls.insecureSkipVerify"); err != nil {
+		return opts, err
+	}
+
+	// Valida subito la configurazione così gli errori emergono alla creazione del client
+	if _, err := opts.Config(""); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+// Config costruisce la tls.Config per l'host indicato
+// Se ServerName non è impostato viene usato l'host (SNI)
+func (o TLSOptions) Config(host string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if o.ServerName != "" {
+		config.ServerName = o.ServerName
+	}
+
+	if o.CA != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(o.CA)) {
+			return nil, fmt.Errorf("tls.ca does not contain any valid PEM certificate")
+		}
+		config.RootCAs = pool
+	}
+
+	if o.Cert != "" || o.Key != "" {
+		cert, err := tls.X509KeyPair([]byte(o.Cert), []byte(o.Key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls.cert/tls.key: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	var err error
+	if config.MinVersion, err = parseTLSVersion(o.MinVersion); err != nil {
+		return nil, err
+	}
+	if config.MaxVersion, err = parseTLSVersion(o.MaxVersion); err != nil {
+		return nil, err
+	}
+
+	if len(o.CipherSuites) > 0 {
+		suites, err := parseCipherSuites(o.CipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		config.CipherSuites = suites
+	}
+
+	return config, nil
+}
+
+// parseTLSVersion converte "1.0", "1.1", "1.2" o "1.3" nella costante di crypto/tls
+// Una stringa vuota lascia il default di Go
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q, use one of 1.0, 1.1, 1.2, 1.3", version)
+}
+
+// parseCipherSuites converte i nomi delle cipher suite (es. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) nei loro ID
+func parseCipherSuites(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,149 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertificate genera un certificato autofirmato e la sua chiave in formato PEM
+func testCertificate(t *testing.T) (certPEM, keyPEM string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "imap.test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certPEM, keyPEM
+}
+
+func TestParseTLSOptions(t *testing.T) {
+	certPEM, keyPEM := testCertificate(t)
+	_, otherKeyPEM := testCertificate(t)
+
+	tests := []struct {
+		name    string
+		obj     map[string]interface{}
+		wantErr string
+		check   func(t *testing.T, opts TLSOptions, config *tls.Config)
+	}{
+		{
+			name: "empty object keeps the defaults",
+			obj:  map[string]interface{}{},
+			check: func(t *testing.T, opts TLSOptions, config *tls.Config) {
+				if config.ServerName != "imap.example.com" || config.InsecureSkipVerify {
+					t.Errorf("ServerName = %q, InsecureSkipVerify = %v", config.ServerName, config.InsecureSkipVerify)
+				}
+				if config.RootCAs != nil || config.Certificates != nil || config.MinVersion != 0 || config.MaxVersion != 0 || config.CipherSuites != nil {
+					t.Errorf("unexpected non-default config %+v", config)
+				}
+			},
+		},
+		{
+			name: "server name overrides the host",
+			obj:  map[string]interface{}{"serverName": "mail.internal", "insecureSkipVerify": true},
+			check: func(t *testing.T, opts TLSOptions, config *tls.Config) {
+				if config.ServerName != "mail.internal" || !config.InsecureSkipVerify {
+					t.Errorf("ServerName = %q, InsecureSkipVerify = %v", config.ServerName, config.InsecureSkipVerify)
+				}
+			},
+		},
+		{
+			name: "custom CA and client certificate",
+			obj:  map[string]interface{}{"ca": certPEM, "cert": certPEM, "key": keyPEM},
+			check: func(t *testing.T, opts TLSOptions, config *tls.Config) {
+				if config.RootCAs == nil {
+					t.Error("RootCAs not set")
+				}
+				if len(config.Certificates) != 1 {
+					t.Errorf("got %d client certificates, want 1", len(config.Certificates))
+				}
+			},
+		},
+		{
+			name: "versions and cipher suites",
+			obj: map[string]interface{}{
+				"minVersion":   "1.2",
+				"maxVersion":   "1.3",
+				"cipherSuites": []interface{}{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_AES_128_CBC_SHA"},
+			},
+			check: func(t *testing.T, opts TLSOptions, config *tls.Config) {
+				if config.MinVersion != tls.VersionTLS12 || config.MaxVersion != tls.VersionTLS13 {
+					t.Errorf("versions = %x-%x", config.MinVersion, config.MaxVersion)
+				}
+				want := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_128_CBC_SHA}
+				if len(config.CipherSuites) != 2 || config.CipherSuites[0] != want[0] || config.CipherSuites[1] != want[1] {
+					t.Errorf("CipherSuites = %x, want %x", config.CipherSuites, want)
+				}
+			},
+		},
+		{
+			name: "all TLS versions",
+			obj:  map[string]interface{}{"minVersion": "1.0", "maxVersion": "1.1"},
+			check: func(t *testing.T, opts TLSOptions, config *tls.Config) {
+				if config.MinVersion != tls.VersionTLS10 || config.MaxVersion != tls.VersionTLS11 {
+					t.Errorf("versions = %x-%x", config.MinVersion, config.MaxVersion)
+				}
+			},
+		},
+		{name: "ca of wrong type", obj: map[string]interface{}{"ca": int64(1)}, wantErr: "option tls.ca must be a string"},
+		{name: "insecure not a boolean", obj: map[string]interface{}{"insecureSkipVerify": "yes"}, wantErr: "option tls.insecureSkipVerify must be a boolean"},
+		{name: "cipher suites not an array", obj: map[string]interface{}{"cipherSuites": int64(1)}, wantErr: "option tls.cipherSuites must be an array of strings"},
+		{name: "invalid CA", obj: map[string]interface{}{"ca": "not a certificate"}, wantErr: "tls.ca does not contain any valid PEM certificate"},
+		{name: "cert without key", obj: map[string]interface{}{"cert": certPEM}, wantErr: "invalid tls.cert/tls.key"},
+		{name: "key without cert", obj: map[string]interface{}{"key": keyPEM}, wantErr: "invalid tls.cert/tls.key"},
+		{name: "mismatched key", obj: map[string]interface{}{"cert": certPEM, "key": otherKeyPEM}, wantErr: "invalid tls.cert/tls.key"},
+		{name: "unsupported min version", obj: map[string]interface{}{"minVersion": "1.4"}, wantErr: `unsupported TLS version "1.4"`},
+		{name: "unsupported max version", obj: map[string]interface{}{"maxVersion": "TLS1.2"}, wantErr: `unsupported TLS version "TLS1.2"`},
+		{name: "unknown cipher suite", obj: map[string]interface{}{"cipherSuites": []interface{}{"TLS_FAKE"}}, wantErr: `unknown TLS cipher suite "TLS_FAKE"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := parseTLSOptions(tt.obj)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTLSOptions: %v", err)
+			}
+
+			config, err := opts.Config("imap.example.com")
+			if err != nil {
+				t.Fatalf("Config: %v", err)
+			}
+			tt.check(t, opts, config)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package client
+
+import "sync"
+
+// waitSet tiene i canali di cancellazione delle attese di WaitNewEmail in corso, per id
+// Ogni attesa ha il suo canale, così più attese sullo stesso client si annullano una per una
+type waitSet struct {
+	mu     sync.Mutex
+	lastId uint64
+	cancel map[uint64]chan struct{}
+}
+
+// add registra una nuova attesa e ne restituisce l'id e il canale di cancellazione
+func (w *waitSet) add() (uint64, <-chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.cancel == nil {
+		w.cancel = make(map[uint64]chan struct{})
+	}
+	w.lastId++
+	ch := make(chan struct{})
+	w.cancel[w.lastId] = ch
+	return w.lastId, ch
+}
+
+// done rimuove un'attesa terminata
+func (w *waitSet) done(id uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.cancel, id)
+}
+
+// cancelWait annulla l'attesa indicata; false se è già terminata
+func (w *waitSet) cancelWait(id uint64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ch, ok := w.cancel[id]
+	if !ok {
+		return false
+	}
+	close(ch)
+	delete(w.cancel, id)
+	return true
+}
+
+// cancelLatest annulla l'ultima attesa avviata ancora in corso; false se non ce ne sono
+func (w *waitSet) cancelLatest() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var latest uint64
+	for id := range w.cancel {
+		if id > latest {
+			latest = id
+		}
+	}
+	if latest == 0 {
+		return false
+	}
+	close(w.cancel[latest])
+	delete(w.cancel, latest)
+	return true
+}
+
+// cancelAll annulla tutte le attese in corso e restituisce quante erano
+func (w *waitSet) cancelAll() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	count := len(w.cancel)
+	for id, ch := range w.cancel {
+		close(ch)
+		delete(w.cancel, id)
+	}
+	return count
+}
@@ -0,0 +1,169 @@
+package client
+
+import (
+	"sync"
+	"testing"
+)
+
+// isClosed dice se il canale di cancellazione di un'attesa è stato chiuso
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestWaitSet(t *testing.T) {
+	tests := []struct {
+		name string
+		// run opera su tre attese appena aggiunte (id 1, 2, 3)
+		run func(t *testing.T, w *waitSet)
+		// closed sono le attese il cui canale deve risultare chiuso
+		closed []bool
+		// pending è il numero di attese ancora registrate
+		pending int
+	}{
+		{
+			name:    "add only",
+			run:     func(t *testing.T, w *waitSet) {},
+			closed:  []bool{false, false, false},
+			pending: 3,
+		},
+		{
+			name: "cancel one",
+			run: func(t *testing.T, w *waitSet) {
+				if !w.cancelWait(2) {
+					t.Error("cancelWait(2) = false")
+				}
+				if w.cancelWait(2) {
+					t.Error("cancelWait(2) again = true")
+				}
+			},
+			closed:  []bool{false, true, false},
+			pending: 2,
+		},
+		{
+			name: "cancel unknown id",
+			run: func(t *testing.T, w *waitSet) {
+				if w.cancelWait(42) {
+					t.Error("cancelWait(42) = true")
+				}
+			},
+			closed:  []bool{false, false, false},
+			pending: 3,
+		},
+		{
+			name: "done removes without closing",
+			run: func(t *testing.T, w *waitSet) {
+				w.done(1)
+				w.done(1)
+				if w.cancelWait(1) {
+					t.Error("cancelWait after done = true")
+				}
+			},
+			closed:  []bool{false, false, false},
+			pending: 2,
+		},
+		{
+			name: "cancel latest",
+			run: func(t *testing.T, w *waitSet) {
+				if !w.cancelLatest() {
+					t.Error("first cancelLatest = false")
+				}
+				if !w.cancelLatest() {
+					t.Error("second cancelLatest = false")
+				}
+			},
+			closed:  []bool{false, true, true},
+			pending: 1,
+		},
+		{
+			name: "cancel latest skips finished waits",
+			run: func(t *testing.T, w *waitSet) {
+				w.done(3)
+				if !w.cancelLatest() {
+					t.Error("cancelLatest = false")
+				}
+			},
+			closed:  []bool{false, true, false},
+			pending: 1,
+		},
+		{
+			name: "cancel all",
+			run: func(t *testing.T, w *waitSet) {
+				w.done(2)
+				if got := w.cancelAll(); got != 2 {
+					t.Errorf("cancelAll() = %d, want 2", got)
+				}
+				if got := w.cancelAll(); got != 0 {
+					t.Errorf("cancelAll() again = %d, want 0", got)
+				}
+				if w.cancelLatest() {
+					t.Error("cancelLatest after cancelAll = true")
+				}
+			},
+			closed:  []bool{true, false, true},
+			pending: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w waitSet
+			var chans []<-chan struct{}
+			for i := 1; i <= 3; i++ {
+				id, ch := w.add()
+				if id != uint64(i) {
+					t.Fatalf("add() id = %d, want %d", id, i)
+				}
+				chans = append(chans, ch)
+			}
+
+			tt.run(t, &w)
+
+			for i, want := range tt.closed {
+				if got := isClosed(chans[i]); got != want {
+					t.Errorf("wait %d closed = %v, want %v", i+1, got, want)
+				}
+			}
+			if len(w.cancel) != tt.pending {
+				t.Errorf("pending waits = %d, want %d", len(w.cancel), tt.pending)
+			}
+		})
+	}
+}
+
+func TestWaitSetIdsNotReused(t *testing.T) {
+	var w waitSet
+	id, _ := w.add()
+	w.cancelWait(id)
+	w.cancelAll()
+	if next, _ := w.add(); next <= id {
+		t.Errorf("add() after cancel = %d, want > %d", next, id)
+	}
+}
+
+func TestWaitSetConcurrent(t *testing.T) {
+	var w waitSet
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, ch := w.add()
+			if i%2 == 0 {
+				w.cancelWait(id)
+				<-ch
+			} else {
+				w.done(id)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := w.cancelAll(); got != 0 {
+		t.Errorf("cancelAll() = %d, want 0", got)
+	}
+}
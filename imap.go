@@ -2,13 +2,8 @@ package imap
 
 import (
 	"errors"
-	"io/ioutil"
-	"mime/quotedprintable"
-	"net/textproto"
-	"strconv"
+	"fmt"
 
-	"github.com/emersion/go-imap"
-	"github.com/emersion/go-imap/client"
 	"github.com/grafana/sobek"
 
 	ec "github.com/PaoloLeggio/xk6-imap/client"
@@ -23,7 +18,8 @@ type (
 
 	// ModuleInstance represents an instance of the JS module.
 	ModuleInstance struct {
-		vu modules.VU
+		vu      modules.VU
+		metrics *ec.Metrics
 	}
 )
 
@@ -44,8 +40,14 @@ func New() *RootModule {
 
 // NewModuleInstance implements the modules.Module interface and returns
 // a new instance for each VU.
+// The IMAP metrics are registered here, while still in the init context.
 func (*RootModule) NewModuleInstance(vu modules.VU) modules.Instance {
-	return &ModuleInstance{vu: vu}
+	m, err := ec.RegisterMetrics(vu.InitEnv().Registry)
+	if err != nil {
+		common.Throw(vu.Runtime(), err)
+	}
+
+	return &ModuleInstance{vu: vu, metrics: m}
 }
 
 // Exports implements the modules.Instance interface and returns
@@ -54,135 +56,100 @@ func (*RootModule) NewModuleInstance(vu modules.VU) modules.Instance {
 // Questo permette "import Imap from 'k6/x/imap'" e poi "new Imap.Client(...)"
 func (mi *ModuleInstance) Exports() modules.Exports {
 	rt := mi.vu.Runtime()
-	
+
 	// Crea un oggetto JavaScript che contiene Client come proprietà
 	exportsObj := rt.NewObject()
-	
+
 	// Wrappa la funzione EmailClient come costruttore JavaScript
 	// Usa ToValue per convertire la funzione Go in un valore sobek
 	clientConstructor := rt.ToValue(mi.EmailClient)
 	exportsObj.Set("Client", clientConstructor)
-	
+	exportsObj.Set("buildMessage", mi.BuildMessage)
+	exportsObj.Set("read", mi.Read)
+
 	return modules.Exports{
 		Default: exportsObj,
 		Named: map[string]interface{}{
-			"Client": mi.EmailClient,
+			"Client":       mi.EmailClient,
+			"buildMessage": mi.BuildMessage,
+			"read":         mi.Read,
 		},
 	}
 }
 
-// convertJSObjectToMIMEHeader converte un oggetto JavaScript in textproto.MIMEHeader
-// Gestisce la conversione da map[string]interface{} (come viene passato da k6) a textproto.MIMEHeader
-func convertJSObjectToMIMEHeader(obj map[string]interface{}) textproto.MIMEHeader {
-	header := make(textproto.MIMEHeader)
-	
-	for key, value := range obj {
-		switch v := value.(type) {
-		case []interface{}:
-			// Array di valori JavaScript
-			values := make([]string, 0, len(v))
-			for _, item := range v {
-				if str, ok := item.(string); ok {
-					values = append(values, str)
-				}
-			}
-			if len(values) > 0 {
-				header[key] = values
-			}
-		case string:
-			// Singola stringa
-			header[key] = []string{v}
-		case []string:
-			// Già un array di stringhe (caso raro ma possibile)
-			header[key] = v
-		}
-	}
-	
-	return header
-}
-
 // Simple function for one time read
 // Use EmailClient for more complex needs
-func (mi *ModuleInstance) Read(email, password, URL string, port int, headerObj map[string]interface{}) (string, string) {
-	c, err := client.DialTLS(URL+":"+strconv.Itoa(port), nil)
+// optionsObj accetta le stesse opzioni del costruttore Client (es. tls, mailbox, errorMode)
+// Con errorMode "throw" restituisce il body e lancia un ImapError invece di restituire [body, errore]
+func (mi *ModuleInstance) Read(email, password, URL string, port int, headerObj map[string]interface{}, optionsObj map[string]interface{}) sobek.Value {
+	body, err := mi.read(email, password, URL, port, headerObj, optionsObj)
+	return ec.Result(mi.vu.Runtime(), ec.ErrorModeOf(optionsObj), body, err)
+}
 
+func (mi *ModuleInstance) read(email, password, URL string, port int, headerObj map[string]interface{}, optionsObj map[string]interface{}) (string, error) {
+	opts, err := ec.ParseOptions(optionsObj)
 	if err != nil {
-		return "", err.Error()
+		return "", err
 	}
 
-	defer c.Logout()
-
-	if err := c.Login(email, password); err != nil {
-		return "", err.Error()
+	// Usa un EmailClient temporaneo così anche questa lettura emette le metriche IMAP
+	c := &ec.EmailClient{
+		Vu:       mi.vu,
+		Email:    email,
+		Password: password,
+		Url:      URL,
+		Port:     port,
+		Options:  opts,
+		Metrics:  mi.metrics,
 	}
 
-	_, err = c.Select("INBOX", true)
+	defer c.Logout()
 
-	if err != nil {
-		return "", err.Error()
+	if err := c.Login(); err != nil {
+		return "", err
 	}
 
-	// Converti l'oggetto JavaScript in textproto.MIMEHeader
-	header := convertJSObjectToMIMEHeader(headerObj)
-
-	criteria := &imap.SearchCriteria{
-		Header: header,
-	}
+	return ec.ReadFirstText(c, headerObj)
+}
 
-	ids, err := c.Search(criteria)
+// BuildMessage compone un messaggio RFC 5322 (vedi client.BuildMessage) da passare ad append o a un client SMTP
+// buildOpts è facoltativo: { format: "string" | "arraybuffer", errorMode } sceglie il tipo restituito (default stringa)
+// e, con errorMode "throw", lancia un ImapError invece di restituire [messaggio, errore]
+// Usage: const [raw, err] = Imap.buildMessage({ from: "Acme <noreply@acme.com>", to: "test@acme.com", subject: "Hi", text: "Hello" });
+func (mi *ModuleInstance) BuildMessage(spec map[string]interface{}, buildOpts map[string]interface{}) sobek.Value {
+	message, err := mi.buildMessage(spec, buildOpts)
+	return ec.Result(mi.vu.Runtime(), ec.ErrorModeOf(buildOpts), message, err)
+}
 
-	if err != nil {
-		return "", err.Error()
+func (mi *ModuleInstance) buildMessage(spec map[string]interface{}, buildOpts map[string]interface{}) (interface{}, error) {
+	if spec == nil {
+		return nil, errors.New("buildMessage requires a message object")
 	}
 
-	if len(ids) == 0 {
-		return "", "No messages found"
+	format, _ := buildOpts["format"].(string)
+	if format != "" && format != "string" && format != "arraybuffer" {
+		return nil, fmt.Errorf("unsupported format %q, use one of string, arraybuffer", format)
 	}
 
-	seqSet := new(imap.SeqSet)
-	seqSet.AddNum(ids...)
-
-	items := []imap.FetchItem{imap.FetchItem("BODY[TEXT]")}
-
-	messages := make(chan *imap.Message, 1)
-
-	err = c.Fetch(seqSet, items, messages)
-
+	data, err := ec.BuildMessage(spec)
 	if err != nil {
-		return "", err.Error()
+		return nil, err
 	}
 
-	msg := <-messages
-
-	if msg == nil {
-		return "", "No message"
+	if format == "arraybuffer" {
+		return mi.vu.Runtime().NewArrayBuffer(data), nil
 	}
-
-	section, _ := imap.ParseBodySectionName("BODY[TEXT]")
-	r := msg.GetBody(section)
-
-	if r == nil {
-		return "", "Could not get message body"
-	}
-
-	qr := quotedprintable.NewReader(r)
-	bs, err := ioutil.ReadAll(qr)
-
-	if err != nil {
-		return "", err.Error()
-	}
-
-	return string(bs), "" // TODO Maybe return "OK"
+	return string(data), nil
 }
 
 // EmailClient is the JS constructor for the email client.
-// It accepts email, password, url, and port as arguments.
-// Usage: const client = new Imap.Client(email, password, url, port);
+// It accepts email, password, url, port and an optional options object as arguments.
+// Usage: const client = new Imap.Client(email, password, url, port, { tls: { ca: caPem } });
 func (mi *ModuleInstance) EmailClient(call sobek.ConstructorCall) *sobek.Object {
 	rt := mi.vu.Runtime()
 
-	if len(call.Arguments) != 4 {
-		common.Throw(rt, errors.New("Client requires 4 arguments: email, password, url, port"))
+	if len(call.Arguments) != 4 && len(call.Arguments) != 5 {
+		common.Throw(rt, errors.New("Client requires 4 or 5 arguments: email, password, url, port, [options]"))
 		return nil
 	}
 
@@ -219,13 +186,32 @@ func (mi *ModuleInstance) EmailClient(call sobek.ConstructorCall) *sobek.Object
 		return nil
 	}
 
+	// Opzioni facoltative (quinto argomento)
+	var optionsObj map[string]interface{}
+	if len(call.Arguments) == 5 && !sobek.IsUndefined(call.Arguments[4]) && !sobek.IsNull(call.Arguments[4]) {
+		optionsObj, ok = call.Arguments[4].Export().(map[string]interface{})
+		if !ok {
+			common.Throw(rt, errors.New("fifth argument (options) must be an object"))
+			return nil
+		}
+	}
+
+	opts, err := ec.ParseOptions(optionsObj)
+	if err != nil {
+		common.Throw(rt, err)
+		return nil
+	}
+
 	client := &ec.EmailClient{
 		Vu:       mi.vu,
 		Email:    email,
 		Password: password,
 		Url:      url,
 		Port:     portInt,
+		Options:  opts,
+		Metrics:  mi.metrics,
 	}
 
-	return rt.ToValue(client).ToObject(rt)
+	// Con errorMode "throw" i metodi lanciano ImapError invece di restituire [valore, errore]
+	return ec.NewClientObject(client)
 }